@@ -3,21 +3,58 @@ package minio
 import (
     "context"
     "errors"
+    "fmt"
     "strings"
     "sync"
+    "time"
 
     "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/helper/locksutil"
     "github.com/hashicorp/vault/sdk/logical"
+    "github.com/hashicorp/vault/sdk/queue"
 
     "github.com/minio/madmin-go/v3"
+    cr "github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+    // How long a connection verification may take before giving up
+    verifyConnectionTimeout = 10 * time.Second
 )
 
 type minioBackend struct {
     *framework.Backend
 
-    client *madmin.AdminClient
+    // clients caches one madmin client per connection name, the unnamed
+    // connection being config/root
+    clients map[string]*madmin.AdminClient
+
+    clientsMutex sync.Mutex
+
+    // usersMigrated is set once users were moved out of the legacy
+    // userStoragePath map
+    usersMigrated bool
+
+    usersMigrationMutex sync.Mutex
+
+    // configMutex serializes root rotations with writes and deletes of
+    // connections, which would otherwise overwrite each other's pending
+    // admin secret
+    configMutex sync.Mutex
+
+    // roleLocks serialize issuance, revocation and deletion per role name
+    roleLocks []*locksutil.LockEntry
 
-    clientMutex sync.RWMutex
+    // tidyMutex prevents concurrent tidy runs, lastTidy being the start of
+    // the last periodic one
+    tidyMutex sync.Mutex
+    lastTidy  time.Time
+
+    // credRotationQueue orders static roles by their next rotation
+    credRotationQueue *queue.PriorityQueue
+
+    // cancelQueue stops the goroutine rotating static roles
+    cancelQueue context.CancelFunc
 }
 
 // Factory returns a configured instance of the minio backend
@@ -41,8 +78,12 @@ func Backend() *minioBackend {
     PathsSpecial: &logical.Paths{
         SealWrapStorage: []string{
             configStoragePath,
+            connectionStoragePrefix + "*",
             "roles/*",
             userStoragePath,
+            userStoragePrefix + "*",
+            staticRoleStoragePrefix + "*",
+            libraryUserStoragePrefix + "*",
         },
     },
     Paths: []*framework.Path{
@@ -50,6 +91,16 @@ func Backend() *minioBackend {
         // ^config
         b.pathConfigCRUD(),
 
+        // path_connections.go
+        // ^config/connections (LIST)
+        b.pathConnections(),
+        // ^config/connections/<name>
+        b.pathConnectionsCRUD(),
+
+        // path_config_rotate_root.go
+        // ^config/rotate-root
+        b.pathConfigRotateRoot(),
+
         // path_roles.go
         // ^roles (LIST)
         b.pathRoles(),
@@ -60,35 +111,124 @@ func Backend() *minioBackend {
         // ^creds/<role>
         // ^sts/<role>
         b.pathKeysRead(),
+
+        // path_revoke.go
+        // ^revoke
+        b.pathRevoke(),
+
+        // path_static_roles.go
+        // ^static-roles (LIST)
+        b.pathStaticRoles(),
+        // ^static-roles/<name>
+        b.pathStaticRolesCRUD(),
+        // ^static-creds/<name>
+        b.pathStaticCreds(),
+
+        // path_library.go
+        // ^library (LIST)
+        b.pathLibrary(),
+        // ^library/<name>
+        b.pathLibraryCRUD(),
+        // ^library/<name>/check-out
+        b.pathLibraryCheckOut(),
+        // ^library/<name>/check-in
+        b.pathLibraryCheckIn(),
+        // ^library/<name>/status
+        b.pathLibraryStatus(),
+
+        // path_policies.go
+        // ^policies (LIST)
+        b.pathPolicies(),
+        // ^policies/<name>
+        b.pathPoliciesCRUD(),
+
+        // path_tidy.go
+        // ^config/tidy
+        b.pathConfigTidy(),
+        // ^tidy
+        b.pathTidy(),
     },
+    Secrets: []*framework.Secret{
+        // secret_keys.go
+        b.secretStaticKeys(),
+        b.secretStsKeys(),
+        b.secretServiceAccountKeys(),
+        b.secretLibraryKeys(),
+    },
+
+    // path_tidy.go
+    PeriodicFunc: b.periodicFunc,
+
+    // rollback.go
+    WALRollback: b.walRollback,
+    WALRollbackMinAge: walRollbackMinAge,
+
+    // rotation.go
+    InitializeFunc: b.initQueue,
+    Clean: b.clean,
     }
 
-    b.client = (*madmin.AdminClient)(nil)
+    b.clients = make(map[string]*madmin.AdminClient)
+    b.roleLocks = locksutil.CreateLocks()
+    b.credRotationQueue = queue.New()
 
     return &b
 }
 
-// Convenience function to get a new madmin client
-func (b *minioBackend) getMadminClient(ctx context.Context, s logical.Storage) (*madmin.AdminClient, error) {
+// roleLock returns the lock guarding the users issued for roleName
+func (b *minioBackend) roleLock(roleName string) *locksutil.LockEntry {
+    return locksutil.LockForKey(b.roleLocks, roleName)
+}
+
+// staticRoleLock returns the lock guarding the static role name
+func (b *minioBackend) staticRoleLock(name string) *locksutil.LockEntry {
+    return locksutil.LockForKey(b.roleLocks, staticRoleStoragePrefix+name)
+}
+
+// libraryLock returns the lock guarding the library set name
+func (b *minioBackend) libraryLock(name string) *locksutil.LockEntry {
+    return locksutil.LockForKey(b.roleLocks, libraryStoragePrefix+name)
+}
 
-    b.Logger().Debug("getMadminClient, getting clientMutext.RLock")
-    b.clientMutex.Lock()
-    defer b.clientMutex.Unlock()
+// Convenience function to get a madmin client for the named connection
+func (b *minioBackend) getMadminClient(ctx context.Context, s logical.Storage, connection string) (*madmin.AdminClient, error) {
 
-    if b.client != nil {
+    b.Logger().Debug("getMadminClient, getting clientsMutex.Lock", "connection", connection)
+    b.clientsMutex.Lock()
+    defer b.clientsMutex.Unlock()
+
+    if client, ok := b.clients[connection]; ok {
         b.Logger().Debug("Already have client, returning")
-        return b.client, nil
+        return client, nil
     }
 
     // Don't have client, look up configuration and gin up new client
-    b.Logger().Info("getMadminClient, need new client and looking up config")
+    b.Logger().Info("getMadminClient, need new client and looking up config", "connection", connection)
 
-    c, err := b.GetConfig(ctx, s)
+    c, err := b.GetConnection(ctx, s, connection)
     if err != nil {
         b.Logger().Error("Error fetching config in getMadminClient", "error", err)
         return nil, err
     }
 
+    if connection != "" && !c.Configured {
+        return nil, fmt.Errorf("connection %q is not configured", connection)
+    }
+
+    client, err := b.newMadminClient(c)
+    if err != nil {
+        return nil, err
+    }
+    
+    b.clients[connection] = client
+    return client, nil
+}
+
+// newMadminClient builds a madmin client from the given configuration
+// without touching the cached backend client
+func (b *minioBackend) newMadminClient(c *Config) (*madmin.AdminClient, error) {
+    var err error
+
     if c.Endpoint == "" {
         err = errors.New("Endpoint not set when trying to create new madmin client")
         b.Logger().Error("Error", "error", err)
@@ -107,24 +247,52 @@ func (b *minioBackend) getMadminClient(ctx context.Context, s logical.Storage) (
         return nil, err
     }
 
-    client, err := madmin.New(c.Endpoint, c.AccessKeyId, c.SecretAccessKey, c.UseSSL)
+    transport, err := c.Transport()
+    if err != nil {
+        b.Logger().Error("Error building madmin client transport", "error", err)
+        return nil, err
+    }
+
+    client, err := madmin.NewWithOptions(c.Endpoint, &madmin.Options{
+        Creds: cr.NewStaticV4(c.AccessKeyId, c.SecretAccessKey, ""),
+        Secure: c.UseSSL,
+        Transport: transport,
+    })
     if err != nil {
         b.Logger().Error("Error getting new madmin client", "error", err)
         return nil, err
     }
-    
-    b.client = client
-    return b.client, nil
+
+    return client, nil
+}
+
+// verifyConnection builds a throwaway madmin client from the given
+// configuration and calls an admin API to make sure the endpoint is
+// reachable and the credentials have admin rights
+func (b *minioBackend) verifyConnection(ctx context.Context, c *Config) error {
+    client, err := b.newMadminClient(c)
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, verifyConnectionTimeout)
+    defer cancel()
+
+    if _, err := client.ServerInfo(ctx); err != nil {
+        return fmt.Errorf("failed to verify connection to %s: %v", c.Endpoint, err)
+    }
+
+    return nil
 }
 
-// Call this to invalidate the current backend client
-func (b *minioBackend) invalidateMadminClient() {
-    b.Logger().Debug("invalidateMadminClient")
+// Call this to invalidate the backend client of the named connection
+func (b *minioBackend) invalidateMadminClient(connection string) {
+    b.Logger().Debug("invalidateMadminClient", "connection", connection)
     
-    b.clientMutex.Lock()
-    defer b.clientMutex.Unlock()
+    b.clientsMutex.Lock()
+    defer b.clientsMutex.Unlock()
 
-    b.client = nil
+    delete(b.clients, connection)
 }
 
 const minioHelp = `
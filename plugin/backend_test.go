@@ -22,4 +22,11 @@ func getMinioBackend(tb testing.TB) (logical.Backend, error) {
     config := logical.TestBackendConfig()
     config.System = logical.TestSystemView()
     return minio.Factory(context.Background(), config)
-}
\ No newline at end of file
+}
+
+// getMinioBackendWithSystemView returns a backend using the given system view
+func getMinioBackendWithSystemView(tb testing.TB, sys logical.SystemView) (logical.Backend, error) {
+    config := logical.TestBackendConfig()
+    config.System = sys
+    return minio.Factory(context.Background(), config)
+}
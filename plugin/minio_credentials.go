@@ -2,48 +2,124 @@ package minio
 
 import (
     "context"
+    "crypto/rand"
+    "errors"
+    "math/big"
     "time"
 
+    "encoding/base32"
     "encoding/base64"
+    "encoding/json"
 
     "fmt"
+    "net/http"
+    "regexp"
+    "strings"
 
     uuid "github.com/hashicorp/go-uuid"
+    "github.com/hashicorp/vault/sdk/helper/template"
     "github.com/hashicorp/vault/sdk/logical"
     "github.com/minio/madmin-go/v3"
     cr "github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 const (
+    // userStoragePath is the legacy single map of every issued user
     userStoragePath      = "users"
+    userStoragePrefix    = "users/"
     minioSecretKeyLength = 32
-    scheme               = "https"
+
+    // Characters of secret keys from the built-in generator by default
+    defaultSecretKeyCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+    // Random bytes of a service account access key, which Minio limits to
+    // 20 characters
+    serviceAccountKeyLength = 10
+
+    // Bounds Minio puts on the name and description of service accounts
+    serviceAccountNameMaxLength        = 32
+    serviceAccountDescriptionMaxLength = 256
+
+    // Bounds Minio puts on user access keys
+    minioUserNameMinLength = 3
+    minioUserNameMaxLength = 128
 )
 
+// Characters allowed in the access key of issued users
+var userNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._@+-]+$`)
+
+// Characters Minio refuses in the name of service accounts
+var serviceAccountNameInvalidRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// userNameTemplateData is the data available to a role's username_template
+type userNameTemplateData struct {
+    RoleName    string
+    DisplayName string
+    EntityName  string
+    RequestID   string
+}
+
 // UserInfo carries information about long term users.
 type UserInfo struct {
     AccessKeyID     string               `json:"accessKeyId,omitempty"`
     SecretAccessKey string               `json:"secretAccessKey,omitempty"`
     PolicyName      string               `json:"policyName,omitempty"`
+    // PolicyNames are the additional policies attached to this user
+    PolicyNames     []string             `json:"policyNames,omitempty"`
+    // InlinePolicy is the canned policy created for this user alone
+    InlinePolicy    string               `json:"inlinePolicy,omitempty"`
+    // Groups are the Minio groups this user was added to
+    Groups          []string             `json:"groups,omitempty"`
+    // Status is enabled until the user is revoked or rotated out, then
+    // disabled until its removal
     Status          madmin.AccountStatus `json:"status"`
     ExpirationDate  time.Time            `json:"expirationDate"`
+    // DisabledAt is when the user was disabled, zero while enabled
+    DisabledAt      time.Time            `json:"disabledAt,omitempty"`
+    // Connection the user was created on, empty for config/root
+    Connection      string               `json:"connection,omitempty"`
+    // EntityID is the Vault entity the user was issued to by a per_entity
+    // role, empty for users shared by the whole role
+    EntityID        string               `json:"entityId,omitempty"`
+    // Leases counts the static credential leases sharing the user, which is
+    // only revoked with the last of them
+    Leases          int                  `json:"leases,omitempty"`
 }
 
-func (b *minioBackend) getActiveUserCreds(ctx context.Context, req *logical.Request, roleName string, role *Role, now time.Time) (*UserInfo, error) {
-    userCredsMap, err := b.getAllUserCreds(ctx, req.Storage)
+// getActiveUserCreds returns the user a request of the role is served by,
+// issuing a new one if needed. The user stays valid for at least
+// minLifetime, such as STS credentials issued from it require.
+func (b *minioBackend) getActiveUserCreds(ctx context.Context, req *logical.Request, roleName string, role *Role,
+    minLifetime time.Duration, now time.Time) (*UserInfo, error) {
+    entityID, err := userEntityID(req, role)
     if err != nil {
         return nil, err
     }
 
-    var newKeyName string
-    if role.UserNamePrefix == "" {
-        newKeyName = req.ID
-    } else {
-        newKeyName = fmt.Sprintf("%s-%s", role.UserNamePrefix, req.ID)
+    users, err := b.getEntityUserCreds(ctx, req.Storage, roleName, entityID)
+    if err != nil {
+        return nil, err
     }
 
-    users, ok := userCredsMap[roleName]
-    if ok {
+    newKeyName, err := b.newUserName(req, roleName, role)
+    if err != nil {
+        return nil, err
+    }
+
+    // Roles with a rotation overlap keep the previous user valid alongside
+    // the new one, and so do users which must outlive what they issue
+    overlap := role.RotationOverlap
+    if minLifetime > overlap {
+        overlap = minLifetime
+    }
+    if overlap > 0 {
+        return b.getOverlappingUserCreds(ctx, req, roleName, role, users, newKeyName, overlap, now)
+    }
+
+    // Disabled users were revoked and only wait for their removal
+    users = enabledUserCreds(users)
+
+    if len(users) > 0 {
         if len(users) == 1 {
             userCreds := users[0]
             if b.isUserCredentialExpired(ctx, now, userCreds) {
@@ -56,19 +132,17 @@ func (b *minioBackend) getActiveUserCreds(ctx context.Context, req *logical.Requ
                 return &userCreds, nil
             }
         } else {
-            oldestCreds, err := b.getOldestUserCreds(ctx, req, roleName)
+            oldestCreds := oldestUserCreds(users)
+            err = b.revokeUser(ctx, req, role, roleName, oldestCreds)
             if err != nil {
                 return nil, err
             }
-            err = b.removeUser(ctx, req, role, roleName, oldestCreds)
+            users, err = b.getEntityUserCreds(ctx, req.Storage, roleName, entityID)
             if err != nil {
                 return nil, err
             }
-            userCredsMap, err = b.getAllUserCreds(ctx, req.Storage)
-            if err != nil {
-                return nil, err
-            }
-            userCreds := userCredsMap[roleName][0]
+            users = enabledUserCreds(users)
+            userCreds := users[0]
             newUserCreds, err := b.addUser(ctx, req, newKeyName, role, roleName, now)
             if err != nil {
                 return nil, err
@@ -81,7 +155,7 @@ func (b *minioBackend) getActiveUserCreds(ctx context.Context, req *logical.Requ
         }
     }
 
-    b.Logger().Info("This roleName", roleName, "is not found in vault!")
+    b.Logger().Info("No user found in vault for role", "role", roleName, "entityId", entityID)
     b.Logger().Info("Application requesting for user credentials for the first time")
 
     userCreds, err := b.addUser(ctx, req, newKeyName, role, roleName, now)
@@ -92,88 +166,507 @@ func (b *minioBackend) getActiveUserCreds(ctx context.Context, req *logical.Requ
 
 }
 
+// getOverlappingUserCreds returns the newest user of a role, issuing a new
+// one once the newest is within overlap of its expiry. Expired users are disabled first and removed on a later
+// request once the revocation grace period elapsed, so clients still holding
+// them fail without being cut off mid-request by a deletion.
+func (b *minioBackend) getOverlappingUserCreds(ctx context.Context, req *logical.Request, roleName string, role *Role,
+    users []UserInfo, newKeyName string, overlap time.Duration, now time.Time) (*UserInfo, error) {
+    c, err := b.getTidyConfig(ctx, req.Storage)
+    if err != nil {
+        return nil, err
+    }
+
+    var active []UserInfo
+    for i := range users {
+        userCreds := &users[i]
+        if userCreds.Status != madmin.AccountDisabled && !b.isUserCredentialExpired(ctx, now, *userCreds) {
+            active = append(active, *userCreds)
+            continue
+        }
+
+        if userCreds.Status == madmin.AccountDisabled {
+            if !isRevocationDue(*userCreds, c.RevocationGracePeriod, now) {
+                continue
+            }
+            if err := b.removeUser(ctx, req, role, roleName, userCreds); err != nil {
+                return nil, err
+            }
+            continue
+        }
+
+        if err := b.disableUser(ctx, req.Storage, roleName, userCreds); err != nil {
+            return nil, err
+        }
+    }
+
+    if len(active) > 0 {
+        newestCreds := newestUserCreds(active)
+        if now.Before(newestCreds.ExpirationDate.Add(-overlap)) {
+            return newestCreds, nil
+        }
+        b.Logger().Info("Rotating user ahead of expiry", "role", roleName, "accessKeyId", newestCreds.AccessKeyID)
+    }
+
+    return b.addUser(ctx, req, newKeyName, role, roleName, now)
+}
+
+// revokeUser disables a user, leaving its removal to the periodic tidy once
+// the revocation grace period elapsed, or removes it without a grace period
+func (b *minioBackend) revokeUser(ctx context.Context, req *logical.Request, role *Role, roleName string, userCreds *UserInfo) error {
+    c, err := b.getTidyConfig(ctx, req.Storage)
+    if err != nil {
+        return err
+    }
+
+    if c.RevocationGracePeriod == 0 {
+        return b.removeUser(ctx, req, role, roleName, userCreds)
+    }
+
+    // Already revoked, its grace period keeps running
+    if userCreds.Status == madmin.AccountDisabled {
+        return nil
+    }
+
+    return b.disableUser(ctx, req.Storage, roleName, userCreds)
+}
+
+// disableUser disables a user on Minio and records when, leaving its
+// removal for later
+func (b *minioBackend) disableUser(ctx context.Context, s logical.Storage, roleName string, userCreds *UserInfo) error {
+    b.Logger().Info("Disabling user by madmin client", "role", roleName, "accessKeyId", userCreds.AccessKeyID)
+
+    client, err := b.getMadminClient(ctx, s, userCreds.Connection)
+    if err != nil {
+        return err
+    }
+
+    err = client.SetUserStatus(ctx, userCreds.AccessKeyID, madmin.AccountDisabled)
+    if err != nil && !isNoSuchUser(err) {
+        return fmt.Errorf("failed to disable user by madmin: %v", err)
+    }
+
+    userCreds.Status = madmin.AccountDisabled
+    userCreds.DisabledAt = time.Now()
+    return b.putUserCreds(ctx, s, roleName, userCreds)
+}
+
 func (b *minioBackend) addUser(ctx context.Context, req *logical.Request, userAccesskey string,
     role *Role, roleName string, now time.Time) (*UserInfo, error) {
     b.Logger().Info("Adding user by madmin client and persisting it inside local storage")
 
-    client, err := b.getMadminClient(ctx, req.Storage)
+    if err := validateUserName(userAccesskey); err != nil {
+        return nil, err
+    }
+
+    entityID, err := userEntityID(req, role)
     if err != nil {
         return nil, err
     }
 
-    secretAccessKey, err := b.generateSecretAccessKey()
+    client, err := b.getMadminClient(ctx, req.Storage, role.Connection)
     if err != nil {
         return nil, err
     }
 
-    err = client.AddUser(ctx, userAccesskey, secretAccessKey)
+    c, err := b.GetConnection(ctx, req.Storage, role.Connection)
     if err != nil {
-        b.Logger().Error("Adding minio user failed", "userAccesskey", userAccesskey, "error", err)
         return nil, err
     }
 
-    // Attaching policy to the user
-    policyAssociationReq := madmin.PolicyAssociationReq{
-        Policies: []string{role.PolicyName},
-        User: userAccesskey,
+    if err := b.checkUserNameUnused(ctx, req.Storage, client, c, role.Connection, userAccesskey); err != nil {
+        return nil, err
     }
 
-    _, err = client.AttachPolicy(ctx, policyAssociationReq)
+    secretAccessKey, err := b.generateSecretAccessKey(ctx, c, role.PasswordPolicy)
     if err != nil {
-        b.Logger().Error("Setting minio user policy failed", "minoUserAccesskey", userAccesskey,
-            "policy", role.PolicyName, "error", err)
         return nil, err
     }
 
-    maxTtl := int(role.MaxTTL.Seconds() / 86400)
-    
+    // Static roles with a policy document get a policy of their own, named
+    // after the user
+    var inlinePolicy string
+    var policyDocument []byte
+    if role.CredentialType == StaticCredentialType && role.PolicyDocument != "" {
+        policyDocument, err = compactPolicyDocument(role.PolicyDocument)
+        if err != nil {
+            return nil, err
+        }
+        inlinePolicy = userAccesskey
+
+        // Never replace a canned policy managed otherwise
+        if _, err := client.InfoCannedPolicy(ctx, inlinePolicy); err == nil {
+            return nil, fmt.Errorf("policy %q for user %q already exists", inlinePolicy, userAccesskey)
+        } else if !isNoSuchPolicy(err) {
+            return nil, fmt.Errorf("failed to read policy by madmin: %v", err)
+        }
+    }
+
+    // Record the user before creating it so a request failing halfway
+    // doesn't leave an untracked user behind
+    entry := &walUser{
+        RoleName:     roleName,
+        AccessKeyID:  userAccesskey,
+        PolicyName:   role.PolicyName,
+        PolicyNames:  role.PolicyNames,
+        InlinePolicy: inlinePolicy,
+        Groups:       role.Groups,
+        Connection:   role.Connection,
+        EntityID:     entityID,
+    }
+    walID, err := b.putUserWAL(ctx, req.Storage, walTypeAddUser, entry)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := b.createMinioUser(ctx, client, entry, secretAccessKey, policyDocument); err != nil {
+        return nil, err
+    }
+
     // Gin up the madmin.UserInfo struct
     userInfo := UserInfo{
         AccessKeyID:     userAccesskey,
         SecretAccessKey: secretAccessKey,
         PolicyName:      role.PolicyName,
+        PolicyNames:     role.PolicyNames,
+        InlinePolicy:    inlinePolicy,
+        Groups:          role.Groups,
         Status:          madmin.AccountEnabled,
-        ExpirationDate:  now.AddDate(0, 0, maxTtl),
+        ExpirationDate:  now.Add(role.MaxTTL),
+        Connection:      role.Connection,
+        EntityID:        entityID,
     }
-    //Update map with userInfo and store it in vault storage
-    userMap, err := b.getAllUserCreds(ctx, req.Storage)
-    if err != nil {
+    // Store userInfo in vault storage under the role
+    if err := b.putUserCreds(ctx, req.Storage, roleName, &userInfo); err != nil {
         return nil, err
     }
-
-    userMap[roleName] = append(userMap[roleName], userInfo)
-
-    b.updateVaultStorage(ctx, req, userMap)
+    b.deleteUserWAL(ctx, req.Storage, walID)
 
     // Destroy any old client which may exist so we get a new one
     // with the next request
-    b.invalidateMadminClient()
+    b.invalidateMadminClient(role.Connection)
 
     return &userInfo, nil
 }
 
+// createMinioUser creates the user recorded in entry with its inline
+// policy, attaches its policies and adds it to its groups
+func (b *minioBackend) createMinioUser(ctx context.Context, client *madmin.AdminClient, entry *walUser,
+    secretAccessKey string, policyDocument []byte) error {
+    userAccesskey := entry.AccessKeyID
+
+    err := client.AddUser(ctx, userAccesskey, secretAccessKey)
+    if err != nil {
+        b.Logger().Error("Adding minio user failed", "userAccesskey", userAccesskey, "error", err)
+        return err
+    }
+
+    if entry.InlinePolicy != "" {
+        if err := client.AddCannedPolicy(ctx, entry.InlinePolicy, policyDocument); err != nil {
+            b.Logger().Error("Adding minio user policy failed", "minoUserAccesskey", userAccesskey, "error", err)
+            return err
+        }
+    }
+
+    // Attaching policies to the user
+    policies := entry.policies()
+    if len(policies) > 0 {
+        policyAssociationReq := madmin.PolicyAssociationReq{
+            Policies: policies,
+            User: userAccesskey,
+        }
+
+        _, err = client.AttachPolicy(ctx, policyAssociationReq)
+        if err != nil {
+            b.Logger().Error("Setting minio user policy failed", "minoUserAccesskey", userAccesskey,
+                "policy", policies, "error", err)
+            return err
+        }
+    }
+
+    for _, group := range entry.Groups {
+        err = client.UpdateGroupMembers(ctx, madmin.GroupAddRemove{
+            Group:   group,
+            Members: []string{userAccesskey},
+        })
+        if err != nil {
+            b.Logger().Error("Adding minio user to group failed", "minoUserAccesskey", userAccesskey,
+                "group", group, "error", err)
+            return err
+        }
+    }
+
+    return nil
+}
+
+// setUserSecret sets secretAccessKey on an existing Minio user, keeping
+// its status
+func (b *minioBackend) setUserSecret(ctx context.Context, s logical.Storage, connection string, username string, secretAccessKey string) error {
+    client, err := b.getMadminClient(ctx, s, connection)
+    if err != nil {
+        return err
+    }
+
+    userInfo, err := client.GetUserInfo(ctx, username)
+    if err != nil {
+        return err
+    }
+
+    status := userInfo.Status
+    if status == "" {
+        status = madmin.AccountEnabled
+    }
+
+    if err := client.SetUser(ctx, username, secretAccessKey, status); err != nil {
+        return fmt.Errorf("failed to set user secret key by madmin: %v", err)
+    }
+
+    return nil
+}
+
+// serviceAccountName names a service account after the role, within what
+// Minio accepts: a letter first, then letters, digits, '_' and '-'
+func serviceAccountName(roleName string) string {
+    name := "vault-" + serviceAccountNameInvalidRegex.ReplaceAllString(roleName, "-")
+    if len(name) > serviceAccountNameMaxLength {
+        name = name[:serviceAccountNameMaxLength]
+    }
+    return name
+}
+
+// serviceAccountDescription ties a service account to the role and the
+// request issuing it. The role name is shortened first, so the request ID
+// is always kept.
+func serviceAccountDescription(roleName string, requestID string) string {
+    suffix := fmt.Sprintf(", request %s", requestID)
+    prefix := "Issued by Vault for role "
+    if room := serviceAccountDescriptionMaxLength - len(prefix) - len(suffix); len(roleName) > room {
+        roleName = roleName[:max(room, 0)]
+    }
+    return prefix + roleName + suffix
+}
+
+// addServiceAccount creates a Minio service account for the role, expiring
+// after the role's MaxTTL
+func (b *minioBackend) addServiceAccount(ctx context.Context, req *logical.Request, role *Role,
+    roleName string, now time.Time) (*madmin.Credentials, error) {
+    b.Logger().Info("Adding service account by madmin client", "role", roleName)
+
+    client, err := b.getMadminClient(ctx, req.Storage, role.Connection)
+    if err != nil {
+        return nil, err
+    }
+
+    // The access key is chosen here so it can be recorded before creation
+    randBytes, err := uuid.GenerateRandomBytes(serviceAccountKeyLength)
+    if err != nil {
+        return nil, fmt.Errorf("error generating random bytes: %v", err)
+    }
+    accessKey := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randBytes)
+
+    expiration := now.Add(role.MaxTTL)
+    addReq := madmin.AddServiceAccountReq{
+        TargetUser:  role.ParentUser,
+        AccessKey:   accessKey,
+        Name:        serviceAccountName(roleName),
+        Description: serviceAccountDescription(roleName, req.ID),
+        Expiration:  &expiration,
+    }
+    if role.PolicyDocument != "" {
+        addReq.Policy = json.RawMessage(role.PolicyDocument)
+    }
+
+    walID, err := b.putUserWAL(ctx, req.Storage, walTypeAddServiceAccount, &walUser{
+        RoleName:    roleName,
+        AccessKeyID: accessKey,
+        Connection:  role.Connection,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    creds, err := client.AddServiceAccount(ctx, addReq)
+    if err != nil {
+        b.Logger().Error("Adding minio service account failed", "accessKey", accessKey, "error", err)
+        return nil, err
+    }
+
+    // From here on the lease revokes the service account
+    b.deleteUserWAL(ctx, req.Storage, walID)
+
+    return &creds, nil
+}
+
+// removeServiceAccount deletes a service account, treating an already
+// missing one as success
+func (b *minioBackend) removeServiceAccount(ctx context.Context, s logical.Storage, connection string, accessKey string) error {
+    b.Logger().Info("Removing service account by madmin client", "accessKey", accessKey)
+    client, err := b.getMadminClient(ctx, s, connection)
+    if err != nil {
+        return fmt.Errorf("failed to receive madmin client: %v", err)
+    }
+
+    err = client.DeleteServiceAccount(ctx, accessKey)
+    if err != nil && madmin.ToErrorResponse(err).Code != "XMinioAdminServiceAccountNotFound" {
+        return fmt.Errorf("failed to delete service account by madmin: %v", err)
+    }
+
+    return nil
+}
+
+// newUserName returns the access key of a new user for the role, rendered
+// from its username_template or made of its prefix and the request ID
+func (b *minioBackend) newUserName(req *logical.Request, roleName string, role *Role) (string, error) {
+    if role.UserNameTemplate == "" {
+        if role.UserNamePrefix == "" {
+            return req.ID, nil
+        }
+        return fmt.Sprintf("%s-%s", role.UserNamePrefix, req.ID), nil
+    }
+
+    tmpl, err := template.NewTemplate(template.Template(role.UserNameTemplate))
+    if err != nil {
+        return "", fmt.Errorf("invalid username_template: %v", err)
+    }
+
+    data := userNameTemplateData{
+        RoleName:    roleName,
+        DisplayName: req.DisplayName,
+        RequestID:   req.ID,
+    }
+
+    if req.EntityID != "" {
+        entity, err := b.System().EntityInfo(req.EntityID)
+        if err != nil {
+            return "", fmt.Errorf("failed to look up entity %s: %v", req.EntityID, err)
+        }
+        if entity != nil {
+            data.EntityName = entity.Name
+        }
+    }
+
+    userName, err := tmpl.Generate(data)
+    if err != nil {
+        return "", fmt.Errorf("failed to render username_template: %v", err)
+    }
+
+    return userName, nil
+}
+
+// validateUserNameTemplate checks a username_template parses and renders a
+// different name for every request, so a user is never issued twice
+func validateUserNameTemplate(userNameTemplate string) error {
+    tmpl, err := template.NewTemplate(template.Template(userNameTemplate))
+    if err != nil {
+        return err
+    }
+
+    var names []string
+    for _, requestID := range []string{"first-request", "second-request"} {
+        name, err := tmpl.Generate(userNameTemplateData{
+            RoleName:    "role",
+            DisplayName: "display",
+            EntityName:  "entity",
+            RequestID:   requestID,
+        })
+        if err != nil {
+            return err
+        }
+        names = append(names, name)
+    }
+
+    if names[0] == names[1] {
+        return errors.New("it must render a unique name per request, using .RequestID or random")
+    }
+
+    return nil
+}
+
+// checkUserNameUnused fails if a new user's access key is already taken on
+// the connection, by any Minio user or by a user this mount manages, which
+// adding the user would otherwise take over
+func (b *minioBackend) checkUserNameUnused(ctx context.Context, s logical.Storage, client *madmin.AdminClient, c *Config,
+    connection string, userName string) error {
+    if userName == c.AccessKeyId {
+        return fmt.Errorf("user name %q is the admin user of the connection", userName)
+    }
+
+    if _, err := client.GetUserInfo(ctx, userName); err == nil {
+        return fmt.Errorf("user name %q is already taken by a Minio user", userName)
+    } else if !isNoSuchUser(err) {
+        return fmt.Errorf("failed to look up user by madmin: %v", err)
+    }
+
+    staticRole, err := b.staticRoleOfUser(ctx, s, connection, userName)
+    if err != nil {
+        return err
+    }
+    if staticRole != "" {
+        return fmt.Errorf("user name %q is taken by static role %q", userName, staticRole)
+    }
+
+    librarySet, err := b.librarySetOfUser(ctx, s, connection, userName)
+    if err != nil {
+        return err
+    }
+    if librarySet != "" {
+        return fmt.Errorf("user name %q is taken by library set %q", userName, librarySet)
+    }
+
+    issuingRole, err := b.roleOfIssuedUser(ctx, s, connection, userName)
+    if err != nil {
+        return err
+    }
+    if issuingRole != "" {
+        return fmt.Errorf("user name %q was already issued by role %q", userName, issuingRole)
+    }
+
+    return nil
+}
+
+// validateUserName checks a generated access key against Minio's constraints
+func validateUserName(userName string) error {
+    if len(userName) < minioUserNameMinLength || len(userName) > minioUserNameMaxLength {
+        return fmt.Errorf("user name %q must be between %d and %d characters long", userName, minioUserNameMinLength, minioUserNameMaxLength)
+    }
+
+    if !userNameRegex.MatchString(userName) {
+        return fmt.Errorf("user name %q may only contain letters, digits and the characters ._@+-", userName)
+    }
+
+    return nil
+}
+
 func (b *minioBackend) getSTS(ctx context.Context, req *logical.Request, userInfo *UserInfo,
     policy string, ttl int) (cr.Value, error) {
 
     b.Logger().Info("Getting STS credentials")
-    var stsEndpoint string
 
-    config, err := b.GetConfig(ctx, req.Storage)
+    config, err := b.GetConnection(ctx, req.Storage, userInfo.Connection)
     if err != nil {
         return cr.Value{}, err
     }
-    stsEndpoint = scheme + "://" + config.Endpoint
+    stsEndpoint := config.StsEndpointURL()
     var stsOpts cr.STSAssumeRoleOptions
     stsOpts.AccessKey = userInfo.AccessKeyID
     stsOpts.SecretKey = userInfo.SecretAccessKey
     stsOpts.Policy = string(policy)
     stsOpts.DurationSeconds = ttl
+    stsOpts.Location = config.Region
 
-    credsObject, err := cr.NewSTSAssumeRole(stsEndpoint, stsOpts)
+    transport, err := config.Transport()
     if err != nil {
         return cr.Value{}, err
     }
 
+    credsObject := cr.New(&cr.STSAssumeRole{
+        Client: &http.Client{
+            Transport: transport,
+        },
+        STSEndpoint: stsEndpoint,
+        Options:     stsOpts,
+    })
+
     v, err := credsObject.Get()
     if err != nil {
         return cr.Value{}, err
@@ -184,63 +677,117 @@ func (b *minioBackend) getSTS(ctx context.Context, req *logical.Request, userInf
 
 func (b *minioBackend) removeUser(ctx context.Context, req *logical.Request, role *Role, roleName string, oldestCreds *UserInfo) error {
     b.Logger().Info("Removing user by madmin client")
-    client, err := b.getMadminClient(ctx, req.Storage)
-    if err != nil {
-        return fmt.Errorf("failed to receive madmin client: %v", err)
+
+    // Record the removal so it is completed should the request fail halfway
+    // Undo what was done to the user when it was added, even if the role
+    // changed since
+    policyName := oldestCreds.PolicyName
+    if policyName == "" {
+        policyName = role.PolicyName
     }
-    policyAssociationReq := madmin.PolicyAssociationReq{
-        Policies: []string{role.PolicyName},
-        User: oldestCreds.AccessKeyID,
+    entry := &walUser{
+        RoleName:     roleName,
+        AccessKeyID:  oldestCreds.AccessKeyID,
+        PolicyName:   policyName,
+        PolicyNames:  oldestCreds.PolicyNames,
+        InlinePolicy: oldestCreds.InlinePolicy,
+        Groups:       oldestCreds.Groups,
+        Connection:   oldestCreds.Connection,
+        EntityID:     oldestCreds.EntityID,
     }
-    _, err = client.DetachPolicy(ctx, policyAssociationReq)
+    walID, err := b.putUserWAL(ctx, req.Storage, walTypeRemoveUser, entry)
     if err != nil {
-        return fmt.Errorf("failed to detach policy by madmin client: %v", err)
-    }
-    if err = client.RemoveUser(ctx, oldestCreds.AccessKeyID); err != nil {
-        return fmt.Errorf("failed to delete user access by madmin: %v", err)
+        return err
     }
 
-    b.Logger().Info("Removing oldest credentials from vault and updating persistent storage")
-    userMap, err := b.getAllUserCreds(ctx, req.Storage)
-    if err != nil {
+    if err := b.removeMinioUser(ctx, req.Storage, entry); err != nil {
         return err
     }
-    if users, exists := userMap[roleName]; exists {
-        if len(users) == 1 {
-            delete(userMap, roleName)
-        } else {
-            //Iterate over users and find userInfo to delete
-            for i, userCred := range users {
-                if userCred.AccessKeyID == oldestCreds.AccessKeyID && userCred.SecretAccessKey == oldestCreds.SecretAccessKey {
-                    userMap[roleName] = append(users[:i], users[i + 1:]...)
-                }
-            }
-        }
+
+    b.Logger().Info("Removing user credentials from vault persistent storage")
+    if err := b.deleteUserCreds(ctx, req.Storage, roleName, oldestCreds.EntityID, oldestCreds.AccessKeyID); err != nil {
+        return err
     }
+    b.deleteUserWAL(ctx, req.Storage, walID)
 
-    b.updateVaultStorage(ctx, req, userMap)
-    b.invalidateMadminClient()
+    b.invalidateMadminClient(oldestCreds.Connection)
     return nil
 }
 
+// userPolicyNames returns the non empty policies attached to a user
+func userPolicyNames(policyNames ...string) []string {
+    var policies []string
+    for _, policyName := range policyNames {
+        if policyName != "" {
+            policies = append(policies, policyName)
+        }
+    }
+    return policies
+}
+
+// removeAllUser revokes every user of a role, leaving those disabled through
+// the revocation grace period to the periodic tidy
 func (b *minioBackend) removeAllUser(ctx context.Context, req *logical.Request, role *Role, roleName string) (error) {
-    userCredsMap, err := b.getAllUserCreds(ctx, req.Storage)
+    users, err := b.getRoleUserCreds(ctx, req.Storage, roleName)
     if err != nil {
         return err
     }
-    if users, exists := userCredsMap[roleName]; exists {
-        for _, userCred := range users {
-            err = b.removeUser(ctx, req, role, roleName, &userCred)
-            if err != nil {
-                return err
-            }
+    for _, userCred := range users {
+        err = b.revokeUser(ctx, req, role, roleName, &userCred)
+        if err != nil {
+            return err
         }
     }
     return nil
 }
 
-func (b *minioBackend) generateSecretAccessKey() (string, error) {
+// generatePasswordFromPolicy generates a secret key from the named Vault
+// password policy
+func (b *minioBackend) generatePasswordFromPolicy(ctx context.Context, passwordPolicy string) (string, error) {
+    secretAccessKey, err := b.System().GeneratePasswordFromPolicy(ctx, passwordPolicy)
+    if err != nil {
+        return "", fmt.Errorf("unable to generate secret key from password_policy %q: %v", passwordPolicy, err)
+    }
+
+    return secretAccessKey, nil
+}
+
+// validatePasswordPolicy checks a secret key can be generated from the
+// password policy, if any, before it is stored for later use
+func (b *minioBackend) validatePasswordPolicy(ctx context.Context, passwordPolicy string) error {
+    if passwordPolicy == "" {
+        return nil
+    }
+
+    _, err := b.generatePasswordFromPolicy(ctx, passwordPolicy)
+    return err
+}
+
+// generateSecretAccessKey generates a user secret key from the role's or
+// the connection's password policy, or with the built-in generator
+func (b *minioBackend) generateSecretAccessKey(ctx context.Context, c *Config, passwordPolicy string) (string, error) {
     b.Logger().Info("Generating secrect access key for user")
+
+    if passwordPolicy == "" {
+        passwordPolicy = c.PasswordPolicy
+    }
+
+    if passwordPolicy != "" {
+        return b.generatePasswordFromPolicy(ctx, passwordPolicy)
+    }
+
+    if c.SecretKeyLength != 0 || c.SecretKeyCharset != "" {
+        length := c.SecretKeyLength
+        if length == 0 {
+            length = minioSecretKeyMaxLength
+        }
+        charset := c.SecretKeyCharset
+        if charset == "" {
+            charset = defaultSecretKeyCharset
+        }
+        return randomString(charset, length)
+    }
+
     randBytes, err := uuid.GenerateRandomBytes(minioSecretKeyLength)
 
     if err != nil {
@@ -250,34 +797,189 @@ func (b *minioBackend) generateSecretAccessKey() (string, error) {
     return base64.StdEncoding.EncodeToString(randBytes), nil
 }
 
-func (b *minioBackend) getAllUserCreds(ctx context.Context, s logical.Storage) (map[string][]UserInfo, error) {
-    b.Logger().Info("Retrieving user info stored in persistent storage")
+// randomString returns length characters picked uniformly from charset
+func randomString(charset string, length int) (string, error) {
+    chars := []rune(charset)
+    max := big.NewInt(int64(len(chars)))
 
-    entry, err := s.Get(ctx, userStoragePath)
+    result := make([]rune, length)
+    for i := range result {
+        n, err := rand.Int(rand.Reader, max)
+        if err != nil {
+            return "", fmt.Errorf("error generating random characters: %v", err)
+        }
+        result[i] = chars[n.Int64()]
+    }
+
+    return string(result), nil
+}
+
+// userCredsPrefix returns where the users of roleName issued to entityID are
+// stored. Users shared by the role are stored under the role itself, and
+// users of per_entity roles one level below, under their entity.
+func userCredsPrefix(roleName string, entityID string) string {
+    if entityID == "" {
+        return userStoragePrefix + roleName + "/"
+    }
+
+    return userStoragePrefix + roleName + "/" + entityID + "/"
+}
+
+// getRoleUserCreds returns every user issued for roleName, to any entity
+func (b *minioBackend) getRoleUserCreds(ctx context.Context, s logical.Storage, roleName string) ([]UserInfo, error) {
+    b.Logger().Info("Retrieving user info stored in persistent storage", "role", roleName)
+
+    if err := b.migrateLegacyUserCreds(ctx, s); err != nil {
+        return nil, err
+    }
+
+    keys, err := s.List(ctx, userCredsPrefix(roleName, ""))
+    if err != nil {
+        return nil, fmt.Errorf("failed to list users of role %s from persistent storage: %v", roleName, err)
+    }
+
+    users := make([]UserInfo, 0, len(keys))
+    for _, key := range keys {
+        if !strings.HasSuffix(key, "/") {
+            userCreds, err := b.getUserCreds(ctx, s, roleName, "", key)
+            if err != nil {
+                return nil, err
+            }
+            // Removed between listing and reading
+            if userCreds != nil {
+                users = append(users, *userCreds)
+            }
+            continue
+        }
+
+        entityUsers, err := b.listUserCreds(ctx, s, roleName, strings.TrimSuffix(key, "/"))
+        if err != nil {
+            return nil, err
+        }
+        users = append(users, entityUsers...)
+    }
+
+    return users, nil
+}
+
+// getEntityUserCreds returns the users of roleName issued to entityID, or
+// the users shared by the role when entityID is empty
+func (b *minioBackend) getEntityUserCreds(ctx context.Context, s logical.Storage, roleName string, entityID string) ([]UserInfo, error) {
+    if err := b.migrateLegacyUserCreds(ctx, s); err != nil {
+        return nil, err
+    }
+
+    return b.listUserCreds(ctx, s, roleName, entityID)
+}
+
+// listUserCreds reads the users stored under userCredsPrefix, skipping the
+// entities of a role listed along with its shared users
+func (b *minioBackend) listUserCreds(ctx context.Context, s logical.Storage, roleName string, entityID string) ([]UserInfo, error) {
+    accessKeyIds, err := s.List(ctx, userCredsPrefix(roleName, entityID))
+    if err != nil {
+        return nil, fmt.Errorf("failed to list users of role %s from persistent storage: %v", roleName, err)
+    }
+
+    users := make([]UserInfo, 0, len(accessKeyIds))
+    for _, accessKeyId := range accessKeyIds {
+        if strings.HasSuffix(accessKeyId, "/") {
+            continue
+        }
+
+        userCreds, err := b.getUserCreds(ctx, s, roleName, entityID, accessKeyId)
+        if err != nil {
+            return nil, err
+        }
+        // Removed between listing and reading
+        if userCreds == nil {
+            continue
+        }
+        users = append(users, *userCreds)
+    }
+
+    return users, nil
+}
+
+func (b *minioBackend) getUserCreds(ctx context.Context, s logical.Storage, roleName string, entityID string, accessKeyId string) (*UserInfo, error) {
+    entry, err := s.Get(ctx, userCredsPrefix(roleName, entityID)+accessKeyId)
     if err != nil {
-        return nil, fmt.Errorf("failed to get user entry map from persistent storage: %v", err)
+        return nil, fmt.Errorf("failed to get user entry from persistent storage: %v", err)
     }
 
-    var userMap = make(map[string][]UserInfo)
-    //if there is no credentials created for the role, entry will be nil. Application requesting creds for first time.
     if entry == nil {
-        return userMap, nil
+        return nil, nil
     }
 
-    if err := entry.DecodeJSON(&userMap); err != nil {
-        return nil, fmt.Errorf("failed to decode user entry map: %v", err)
+    var userCreds UserInfo
+    if err := entry.DecodeJSON(&userCreds); err != nil {
+        return nil, fmt.Errorf("failed to decode user entry: %v", err)
     }
 
-    return userMap, nil
+    return &userCreds, nil
 }
 
-func (b *minioBackend) getOldestUserCreds(ctx context.Context, req *logical.Request, roleName string) (*UserInfo, error) {
-    userInfoMap, err := b.getAllUserCreds(ctx, req.Storage)
+func (b *minioBackend) putUserCreds(ctx context.Context, s logical.Storage, roleName string, userCreds *UserInfo) error {
+    entry, err := logical.StorageEntryJSON(userCredsPrefix(roleName, userCreds.EntityID)+userCreds.AccessKeyID, userCreds)
     if err != nil {
-        return nil, err
+        return fmt.Errorf("failed to generate JSON configuration when adding user details: %v", err)
+    }
+
+    if err := s.Put(ctx, entry); err != nil {
+        return fmt.Errorf("failed to persist user in persistent storage: %v", err)
+    }
+
+    return nil
+}
+
+func (b *minioBackend) deleteUserCreds(ctx context.Context, s logical.Storage, roleName string, entityID string, accessKeyId string) error {
+    if err := s.Delete(ctx, userCredsPrefix(roleName, entityID)+accessKeyId); err != nil {
+        return fmt.Errorf("failed to delete user from persistent storage: %v", err)
+    }
+
+    return nil
+}
+
+// migrateLegacyUserCreds moves users from the single map stored at
+// userStoragePath by older versions of the plugin to one entry per user
+func (b *minioBackend) migrateLegacyUserCreds(ctx context.Context, s logical.Storage) error {
+    b.usersMigrationMutex.Lock()
+    defer b.usersMigrationMutex.Unlock()
+
+    if b.usersMigrated {
+        return nil
+    }
+
+    entry, err := s.Get(ctx, userStoragePath)
+    if err != nil {
+        return fmt.Errorf("failed to get user entry map from persistent storage: %v", err)
+    }
+
+    if entry != nil {
+        var userMap = make(map[string][]UserInfo)
+        if err := entry.DecodeJSON(&userMap); err != nil {
+            return fmt.Errorf("failed to decode user entry map: %v", err)
+        }
+
+        b.Logger().Info("Migrating users from legacy storage", "roles", len(userMap))
+        for roleName, users := range userMap {
+            for _, userCreds := range users {
+                if err := b.putUserCreds(ctx, s, roleName, &userCreds); err != nil {
+                    return err
+                }
+            }
+        }
+
+        if err := s.Delete(ctx, userStoragePath); err != nil {
+            return fmt.Errorf("failed to delete legacy user entry map: %v", err)
+        }
     }
 
-    users := userInfoMap[roleName]
+    b.usersMigrated = true
+    return nil
+}
+
+// oldestUserCreds returns the user of users expiring first
+func oldestUserCreds(users []UserInfo) *UserInfo {
     oldCredential := users[0]    
     for i := 1; i < len(users); i++ {
         if users[i].ExpirationDate.Before(oldCredential.ExpirationDate) {
@@ -285,22 +987,85 @@ func (b *minioBackend) getOldestUserCreds(ctx context.Context, req *logical.Requ
         }
     }
 
-    return &oldCredential, nil
+    return &oldCredential
 }
 
-func (b *minioBackend) isUserCredentialExpired(ctx context.Context, now time.Time, userInfo UserInfo) (bool) {
-    return now.After(userInfo.ExpirationDate)
+// newestUserCreds returns the user of users expiring last
+func newestUserCreds(users []UserInfo) *UserInfo {
+    newCredential := users[0]
+    for i := 1; i < len(users); i++ {
+        if users[i].ExpirationDate.After(newCredential.ExpirationDate) {
+            newCredential = users[i]
+        }
+    }
+
+    return &newCredential
+}
+
+// enabledUserCreds returns the users of users which are not disabled
+func enabledUserCreds(users []UserInfo) []UserInfo {
+    enabled := make([]UserInfo, 0, len(users))
+    for _, userCreds := range users {
+        if userCreds.Status != madmin.AccountDisabled {
+            enabled = append(enabled, userCreds)
+        }
+    }
+
+    return enabled
+}
+
+// isRevocationDue reports whether a disabled user was disabled for at least
+// gracePeriod and may be removed
+func isRevocationDue(userCreds UserInfo, gracePeriod time.Duration, now time.Time) bool {
+    return userCreds.Status == madmin.AccountDisabled && !now.Before(userCreds.DisabledAt.Add(gracePeriod))
 }
 
-func (b *minioBackend) updateVaultStorage(ctx context.Context, req *logical.Request, userMap map[string][]UserInfo) error {
-    entry, err := logical.StorageEntryJSON(userStoragePath, userMap)
+// userEntityID returns the entity owning the users a request is served by,
+// empty unless the role is per_entity
+func userEntityID(req *logical.Request, role *Role) (string, error) {
+    if !role.PerEntity {
+        return "", nil
+    }
+
+    if req.EntityID == "" {
+        return "", errors.New("per_entity roles require the request to be made by a Vault entity")
+    }
+
+    return req.EntityID, nil
+}
+
+// findUserCreds returns the stored credentials for accessKeyId under roleName, or nil if not found.
+// An empty entityID also finds users of any entity, for callers which only
+// know the access key.
+func (b *minioBackend) findUserCreds(ctx context.Context, s logical.Storage, roleName string, entityID string, accessKeyId string) (*UserInfo, error) {
+    if err := b.migrateLegacyUserCreds(ctx, s); err != nil {
+        return nil, err
+    }
+
+    userCreds, err := b.getUserCreds(ctx, s, roleName, entityID, accessKeyId)
+    if err != nil || userCreds != nil || entityID != "" {
+        return userCreds, err
+    }
+
+    keys, err := s.List(ctx, userCredsPrefix(roleName, ""))
     if err != nil {
-        return fmt.Errorf("failed to generate JSON configuration when adding user details: %v", err)
+        return nil, fmt.Errorf("failed to list users of role %s from persistent storage: %v", roleName, err)
     }
 
-    if err := req.Storage.Put(ctx, entry); err != nil {
-        return fmt.Errorf("failed to persist user in persistent storage: %v", err)
+    for _, key := range keys {
+        if !strings.HasSuffix(key, "/") {
+            continue
+        }
+
+        userCreds, err := b.getUserCreds(ctx, s, roleName, strings.TrimSuffix(key, "/"), accessKeyId)
+        if err != nil || userCreds != nil {
+            return userCreds, err
+        }
     }
 
-    return nil
-}
\ No newline at end of file
+    return nil, nil
+}
+
+func (b *minioBackend) isUserCredentialExpired(ctx context.Context, now time.Time, userInfo UserInfo) (bool) {
+    return now.After(userInfo.ExpirationDate)
+}
@@ -0,0 +1,406 @@
+package minio_test
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+    "github.com/minio/minio-go/v7/pkg/s3utils"
+    "github.com/stretchr/testify/require"
+)
+
+const (
+    testAdminPrefix = "/minio/admin/v3"
+)
+
+// testMinioServer is a minimal in-memory stand-in for the Minio admin API,
+// covering only the calls made by the plugin
+type testMinioServer struct {
+    *httptest.Server
+
+    mu sync.Mutex
+
+    // users maps access keys to their secret and status
+    users map[string]madmin.AddOrUpdateUserReq
+
+    // policies maps access keys to their attached policies
+    policies map[string][]string
+
+    // addUserCalls counts every add-user request
+    addUserCalls int
+
+    // serviceAccounts maps service account access keys to their request
+    serviceAccounts map[string]madmin.AddServiceAccountReq
+
+    // cannedPolicies maps policy names to their document
+    cannedPolicies map[string]json.RawMessage
+
+    // groups maps group names to their members
+    groups map[string]map[string]bool
+
+    // failAttach makes policy attachment fail, leaving the user half created
+    failAttach bool
+
+    // adminSecret is the current secret of the admin access key
+    adminSecret string
+
+    // authenticate rejects requests not signed with the current secret of
+    // their access key
+    authenticate bool
+
+    // stsDurations are the durations requested by each AssumeRole call
+    stsDurations []int
+}
+
+func newTestMinioServer(t *testing.T) *testMinioServer {
+    t.Helper()
+    m := &testMinioServer{
+        users:    make(map[string]madmin.AddOrUpdateUserReq),
+        policies: make(map[string][]string),
+        serviceAccounts: make(map[string]madmin.AddServiceAccountReq),
+        cannedPolicies: make(map[string]json.RawMessage),
+        groups: make(map[string]map[string]bool),
+        adminSecret: TEST_APP_OSS_SECRET_ACCESS_KEY,
+    }
+    m.Server = httptest.NewServer(http.HandlerFunc(m.handle))
+    t.Cleanup(m.Close)
+    return m
+}
+
+// endpoint returns the host:port to configure the plugin with
+func (m *testMinioServer) endpoint() string {
+    return strings.TrimPrefix(m.URL, "http://")
+}
+
+// configure points config/root of the given storage at this server
+func (m *testMinioServer) configure(t *testing.T, s logical.Storage) {
+    t.Helper()
+    err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+        "endpoint":        m.endpoint(),
+        "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
+        "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
+        "useSSL":          false,
+    })
+    require.NoError(t, err)
+}
+
+func (m *testMinioServer) userCount() int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return len(m.users)
+}
+
+func (m *testMinioServer) hasUser(accessKey string) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    _, ok := m.users[accessKey]
+    return ok
+}
+
+// addUser creates a user which was not issued by the plugin
+func (m *testMinioServer) addUser(accessKey string, secretKey string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.users[accessKey] = madmin.AddOrUpdateUserReq{SecretKey: secretKey, Status: madmin.AccountEnabled}
+}
+
+func (m *testMinioServer) secretKey(accessKey string) string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.users[accessKey].SecretKey
+}
+
+func (m *testMinioServer) userStatus(accessKey string) madmin.AccountStatus {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.users[accessKey].Status
+}
+
+func (m *testMinioServer) currentAdminSecret() string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.adminSecret
+}
+
+func (m *testMinioServer) setAdminSecret(secretKey string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.adminSecret = secretKey
+}
+
+func (m *testMinioServer) serviceAccount(accessKey string) (madmin.AddServiceAccountReq, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    req, ok := m.serviceAccounts[accessKey]
+    return req, ok
+}
+
+func (m *testMinioServer) handle(w http.ResponseWriter, r *http.Request) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    accessKey := r.URL.Query().Get("accessKey")
+
+    if m.authenticate && !m.verifySignature(r) {
+        m.error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+        return
+    }
+
+    switch strings.TrimPrefix(r.URL.Path, testAdminPrefix) {
+    case "/info":
+        json.NewEncoder(w).Encode(madmin.InfoMessage{Mode: "online"})
+    case "/add-user":
+        var req madmin.AddOrUpdateUserReq
+        if !m.decrypt(w, r, &req) {
+            return
+        }
+        m.addUserCalls++
+        if accessKey == TEST_APP_OSS_ACCESS_KEY_ID {
+            m.adminSecret = req.SecretKey
+            return
+        }
+        m.users[accessKey] = req
+    case "/user-info":
+        user, ok := m.users[accessKey]
+        if !ok {
+            m.error(w, http.StatusNotFound, "XMinioAdminNoSuchUser")
+            return
+        }
+        json.NewEncoder(w).Encode(madmin.UserInfo{Status: user.Status})
+    case "/set-user-status":
+        user, ok := m.users[accessKey]
+        if !ok {
+            m.error(w, http.StatusNotFound, "XMinioAdminNoSuchUser")
+            return
+        }
+        user.Status = madmin.AccountStatus(r.URL.Query().Get("status"))
+        m.users[accessKey] = user
+    case "/remove-user":
+        if _, ok := m.users[accessKey]; !ok {
+            m.error(w, http.StatusNotFound, "XMinioAdminNoSuchUser")
+            return
+        }
+        delete(m.users, accessKey)
+        delete(m.policies, accessKey)
+    case "/idp/builtin/policy/attach", "/idp/builtin/policy/detach":
+        var req madmin.PolicyAssociationReq
+        if !m.decrypt(w, r, &req) {
+            return
+        }
+        if _, ok := m.users[req.User]; !ok {
+            m.error(w, http.StatusNotFound, "XMinioAdminNoSuchUser")
+            return
+        }
+        if m.failAttach && strings.HasSuffix(r.URL.Path, "/attach") {
+            m.error(w, http.StatusInternalServerError, "InternalError")
+            return
+        }
+        if strings.HasSuffix(r.URL.Path, "attach") {
+            m.policies[req.User] = append(m.policies[req.User], req.Policies...)
+        } else {
+            delete(m.policies, req.User)
+        }
+        w.WriteHeader(http.StatusNoContent)
+    case "/add-service-account":
+        var req madmin.AddServiceAccountReq
+        if !m.decrypt(w, r, &req) {
+            return
+        }
+        m.serviceAccounts[req.AccessKey] = req
+        m.encrypt(w, madmin.AddServiceAccountResp{
+            Credentials: madmin.Credentials{
+                AccessKey:  req.AccessKey,
+                SecretKey:  "serviceAccountSecret",
+                Expiration: *req.Expiration,
+            },
+        })
+    case "/delete-service-account":
+        if _, ok := m.serviceAccounts[accessKey]; !ok {
+            m.error(w, http.StatusNotFound, "XMinioAdminServiceAccountNotFound")
+            return
+        }
+        delete(m.serviceAccounts, accessKey)
+        w.WriteHeader(http.StatusNoContent)
+    case "/add-canned-policy":
+        policy, err := io.ReadAll(r.Body)
+        if err != nil {
+            m.error(w, http.StatusBadRequest, "XMinioAdminInvalidArgument")
+            return
+        }
+        m.cannedPolicies[r.URL.Query().Get("name")] = policy
+    case "/info-canned-policy":
+        policy, ok := m.cannedPolicies[r.URL.Query().Get("name")]
+        if !ok {
+            m.error(w, http.StatusNotFound, "XMinioAdminNoSuchPolicy")
+            return
+        }
+        w.Write(policy)
+    case "/list-canned-policies":
+        json.NewEncoder(w).Encode(m.cannedPolicies)
+    case "/remove-canned-policy":
+        name := r.URL.Query().Get("name")
+        if _, ok := m.cannedPolicies[name]; !ok {
+            m.error(w, http.StatusNotFound, "XMinioAdminNoSuchPolicy")
+            return
+        }
+        delete(m.cannedPolicies, name)
+    case "/update-group-members":
+        var req madmin.GroupAddRemove
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            m.error(w, http.StatusBadRequest, "XMinioAdminInvalidArgument")
+            return
+        }
+        if m.groups[req.Group] == nil {
+            m.groups[req.Group] = make(map[string]bool)
+        }
+        for _, member := range req.Members {
+            if req.IsRemove {
+                delete(m.groups[req.Group], member)
+            } else {
+                m.groups[req.Group][member] = true
+            }
+        }
+    case "/":
+        if r.Method != http.MethodPost || r.FormValue("Action") != "AssumeRole" {
+            m.error(w, http.StatusNotImplemented, "NotImplemented")
+            return
+        }
+        duration, err := strconv.Atoi(r.FormValue("DurationSeconds"))
+        if err != nil {
+            m.error(w, http.StatusBadRequest, "InvalidParameterValue")
+            return
+        }
+        m.stsDurations = append(m.stsDurations, duration)
+        expiration := time.Now().Add(time.Duration(duration) * time.Second).UTC().Format(time.RFC3339)
+        fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>`+
+            `<AccessKeyId>stsAccessKey</AccessKeyId><SecretAccessKey>stsSecretKey</SecretAccessKey>`+
+            `<SessionToken>stsSessionToken</SessionToken><Expiration>%s</Expiration>`+
+            `</Credentials></AssumeRoleResult></AssumeRoleResponse>`, expiration)
+    default:
+        m.error(w, http.StatusNotImplemented, "NotImplemented")
+    }
+}
+
+// decrypt reads an encrypted admin request body into v
+func (m *testMinioServer) decrypt(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+    data, err := madmin.DecryptData(m.requestSecret(r), r.Body)
+    if err == nil {
+        err = json.Unmarshal(data, v)
+    }
+    if err != nil {
+        m.error(w, http.StatusBadRequest, "XMinioAdminInvalidArgument")
+        return false
+    }
+    return true
+}
+
+// requestAccessKey returns the access key a request is signed with
+func requestAccessKey(r *http.Request) string {
+    auth := r.Header.Get("Authorization")
+    i := strings.Index(auth, "Credential=")
+    if i < 0 {
+        return ""
+    }
+    credential := auth[i+len("Credential="):]
+    return credential[:strings.Index(credential+"/", "/")]
+}
+
+// requestSecret returns the current secret of the access key a request is
+// signed with, which admin request bodies are encrypted with
+func (m *testMinioServer) requestSecret(r *http.Request) string {
+    accessKey := requestAccessKey(r)
+    if accessKey == TEST_APP_OSS_ACCESS_KEY_ID {
+        return m.adminSecret
+    }
+    return m.users[accessKey].SecretKey
+}
+
+// verifySignature checks the AWS signature V4 of a request against the
+// current secret of its access key
+func (m *testMinioServer) verifySignature(r *http.Request) bool {
+    var credential, signedHeaders, signature string
+    for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), ",") {
+        key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+        switch key {
+        case "Credential":
+            credential = value
+        case "SignedHeaders":
+            signedHeaders = value
+        case "Signature":
+            signature = value
+        }
+    }
+
+    // <access key>/<date>/<region>/<service>/aws4_request
+    scope := strings.SplitN(credential, "/", 2)
+    if len(scope) != 2 {
+        return false
+    }
+    scopeParts := strings.Split(scope[1], "/")
+    if len(scopeParts) != 4 {
+        return false
+    }
+
+    var headers strings.Builder
+    for _, name := range strings.Split(signedHeaders, ";") {
+        value := r.Host
+        if name != "host" {
+            values := r.Header.Values(name)
+            for i, v := range values {
+                values[i] = strings.Join(strings.Fields(v), " ")
+            }
+            value = strings.Join(values, ",")
+        }
+        headers.WriteString(name + ":" + value + "\n")
+    }
+
+    canonicalRequest := strings.Join([]string{
+        r.Method,
+        s3utils.EncodePath(r.URL.Path),
+        strings.ReplaceAll(r.URL.Query().Encode(), "+", "%20"),
+        headers.String(),
+        signedHeaders,
+        r.Header.Get("X-Amz-Content-Sha256"),
+    }, "\n")
+    hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+    stringToSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope[1] + "\n" + hex.EncodeToString(hashedRequest[:])
+
+    key := []byte("AWS4" + m.requestSecret(r))
+    for _, part := range append(scopeParts, stringToSign) {
+        mac := hmac.New(sha256.New, key)
+        mac.Write([]byte(part))
+        key = mac.Sum(nil)
+    }
+
+    return hmac.Equal([]byte(hex.EncodeToString(key)), []byte(signature))
+}
+
+// encrypt writes v as an encrypted admin response body
+func (m *testMinioServer) encrypt(w http.ResponseWriter, v interface{}) {
+    data, err := json.Marshal(v)
+    if err == nil {
+        data, err = madmin.EncryptData(m.adminSecret, data)
+    }
+    if err != nil {
+        m.error(w, http.StatusInternalServerError, "InternalError")
+        return
+    }
+    w.Write(data)
+}
+
+func (m *testMinioServer) error(w http.ResponseWriter, status int, code string) {
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(madmin.ErrorResponse{Code: code, Message: code})
+}
@@ -2,14 +2,23 @@ package minio
 
 import (
     "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
     "strings"
     "fmt"
+    "net/http"
+    "net/url"
+    "regexp"
+    "time"
+
     "github.com/hashicorp/vault/sdk/framework"
     "github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
     configStoragePath = "config/root"
+    connectionStoragePrefix = "config/connections/"
 )
 
 type Config struct {
@@ -18,8 +27,48 @@ type Config struct {
     SecretAccessKey string `json:"secretAccessKey"`
     UseSSL bool `json:"useSSL"`
     Configured bool `json:"is_configured"`
+
+    // CredentialsLastUpdated is when the admin credentials last changed. It
+    // is zero for configurations written before it was recorded.
+    CredentialsLastUpdated time.Time `json:"credentials_last_updated"`
+
+    // PendingSecretAccessKey is the admin secret a root rotation is setting,
+    // kept until Minio is known to use it or not
+    PendingSecretAccessKey string `json:"pending_secret_access_key,omitempty"`
+
+    // StsEndpoint optionally overrides where STS requests are sent. It may be
+    // a full URL or a host[:port], in which case the scheme follows UseSSL.
+    StsEndpoint string `json:"sts_endpoint"`
+
+    // Region passed along with STS AssumeRole requests
+    Region string `json:"region"`
+
+    // TLS settings applied to both the madmin and STS connections
+    CACert string `json:"ca_cert"`
+    ClientCert string `json:"client_cert"`
+    ClientKey string `json:"client_key"`
+    TLSServerName string `json:"tls_server_name"`
+    InsecureSkipVerify bool `json:"insecure_skip_verify"`
+
+    // PasswordPolicy is the Vault password policy generating secret keys,
+    // unless a role sets its own
+    PasswordPolicy string `json:"password_policy"`
+
+    // SecretKeyLength and SecretKeyCharset configure the built-in secret
+    // key generator used without a password policy. When both are unset
+    // secret keys are base64 encoded random bytes.
+    SecretKeyLength int `json:"secret_key_length"`
+    SecretKeyCharset string `json:"secret_key_charset"`
 }
 
+const (
+    // Bounds Minio puts on secret keys
+    minioSecretKeyMinLength = 8
+    minioSecretKeyMaxLength = 40
+)
+
+var regionRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
 // Define the CRU functions for the config path
 func (b *minioBackend) pathConfigCRUD() *framework.Path {
     return &framework.Path{
@@ -27,7 +76,25 @@ func (b *minioBackend) pathConfigCRUD() *framework.Path {
     HelpSynopsis: "Configure the Minio connection.",
     HelpDescription: "Use this endpoint to set the Minio endpoint, accessKeyId, secretAccessKey and SSL settings.",
 
-    Fields: map[string]*framework.FieldSchema{
+    Fields: configFields(),
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathConfigRead,
+        },
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathConfigUpdate,
+        },
+        logical.DeleteOperation: &framework.PathOperation{
+            Callback: b.pathConfigDelete,
+        },
+    },
+    }
+}
+
+// configFields are the fields shared by config/root and config/connections/<name>
+func configFields() map[string]*framework.FieldSchema {
+    return map[string]*framework.FieldSchema{
         "endpoint": &framework.FieldSchema{
         Type: framework.TypeString,
         Description: "The Minio server endpoint.",
@@ -44,42 +111,101 @@ func (b *minioBackend) pathConfigCRUD() *framework.Path {
         Type: framework.TypeBool,
         Description: "(Optional, default `false`) Use SSL to connect to the Minio server.",
         },
-    },
-
-    Operations: map[logical.Operation]framework.OperationHandler{
-        logical.ReadOperation: &framework.PathOperation{
-            Callback: b.pathConfigRead,
+        "sts_endpoint": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) STS endpoint, either a URL or host[:port]. Defaults to the Minio server endpoint, using https when useSSL is set.",
         },
-        logical.UpdateOperation: &framework.PathOperation{
-            Callback: b.pathConfigUpdate,
+        "region": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) Region sent with STS requests.",
         },
-        logical.DeleteOperation: &framework.PathOperation{
-            Callback: b.pathConfigDelete,
+        "ca_cert": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) PEM encoded CA bundle used to verify the Minio server certificate.",
+        },
+        "client_cert": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) PEM encoded client certificate for mutual TLS. Requires client_key.",
+        },
+        "client_key": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) PEM encoded private key for client_cert.",
+        },
+        "tls_server_name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) Server name used to verify the Minio server certificate.",
+        },
+        "insecure_skip_verify": &framework.FieldSchema{
+        Type: framework.TypeBool,
+        Description: "(Optional, default `false`) Skip verification of the Minio server certificate.",
+        },
+        "password_policy": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) Vault password policy used to generate secret keys.",
+        },
+        "secret_key_length": &framework.FieldSchema{
+        Type: framework.TypeInt,
+        Description: "(Optional) Length of secret keys generated without a password policy, between 8 and 40. Defaults to 40 when secret_key_charset is set.",
+        },
+        "secret_key_charset": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "(Optional) Characters of secret keys generated without a password policy. Defaults to letters and digits when secret_key_length is set.",
+        },
+        "verify_connection": &framework.FieldSchema{
+        Type: framework.TypeBool,
+        Default: true,
+        Description: "(Optional, default `true`) Verify the endpoint is reachable and the credentials have admin rights before saving.",
         },
-    },
     }
 }
 
 // Read the current configuration
-func (b *minioBackend) pathConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-    c, err := b.GetConfig(ctx, req.Storage);
+func (b *minioBackend) pathConfigRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := connectionName(d)
+    c, err := b.GetConnection(ctx, req.Storage, name);
     if err != nil {
         return nil, err
     }
 
+    if name != "" && !c.Configured {
+        return nil, nil
+    }
+
+    // Never return the secret itself, nor anything derived from it which
+    // guesses could be checked against
+    lastUpdated := ""
+    if !c.CredentialsLastUpdated.IsZero() {
+        lastUpdated = c.CredentialsLastUpdated.Format(time.RFC3339)
+    }
+
     return &logical.Response{
     Data: map[string]interface{}{
         "endpoint": c.Endpoint,
         "accessKeyId": c.AccessKeyId,
-        "secretAccessKey": c.SecretAccessKey,
         "useSSL": c.UseSSL,
+        "secret_access_key_set": c.SecretAccessKey != "",
+        "credentials_last_updated": lastUpdated,
+        "sts_endpoint": c.StsEndpoint,
+        "region": c.Region,
+        "ca_cert": c.CACert,
+        "client_cert": c.ClientCert,
+        "client_key_set": c.ClientKey != "",
+        "tls_server_name": c.TLSServerName,
+        "insecure_skip_verify": c.InsecureSkipVerify,
+        "password_policy": c.PasswordPolicy,
+        "secret_key_length": c.SecretKeyLength,
+        "secret_key_charset": c.SecretKeyCharset,
     },
     }, nil
 }
 
 // Update the configuration
 func (b *minioBackend) pathConfigUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-    c, err := b.GetConfig(ctx, req.Storage);
+    b.configMutex.Lock()
+    defer b.configMutex.Unlock()
+
+    name := connectionName(d)
+    c, err := b.GetConnection(ctx, req.Storage, name);
     if err != nil {
         return nil, err
     }
@@ -90,33 +216,52 @@ func (b *minioBackend) pathConfigUpdate(ctx context.Context, req *logical.Reques
         return nil, err
     }
 
-    // If we changed the configuration, store it
     if changed {
-        // Make a new storage entry
-        entry, err := logical.StorageEntryJSON(configStoragePath, c)
-        if err != nil {
-            return nil, fmt.Errorf("failed to generate JSON configuration: %v", err)
+        if err := b.validatePasswordPolicy(ctx, c.PasswordPolicy); err != nil {
+            return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
         }
+    }
 
-        // And store it
-        if err := req.Storage.Put(ctx, entry); err != nil {
-            return nil, fmt.Errorf("failed to persist configuration: %v", err)
+    if changed && d.Get("verify_connection").(bool) {
+        if err := b.verifyConnection(ctx, c); err != nil {
+            return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
         }
+    }
 
+    // If we changed the configuration, store it
+    if changed {
+        if err := b.putConfig(ctx, req.Storage, name, c); err != nil {
+            return nil, err
+        }
     }
 
     // Destroy any old client which may exist so we get a new one
     // with the next request
-    b.invalidateMadminClient()
+    b.invalidateMadminClient(name)
 
     return nil, nil
 }
 
+// pathConfigDelete deletes config/root or a named connection unless
+// something still uses it
 func (b *minioBackend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-    err := req.Storage.Delete(ctx, configStoragePath)
+    b.configMutex.Lock()
+    defer b.configMutex.Unlock()
+
+    name := connectionName(data)
+
+    owner, err := b.connectionOwner(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+    if owner != "" {
+        return logical.ErrorResponse("%s is in use by %s", connectionStoragePath(name), owner), logical.ErrInvalidRequest
+    }
+
+    err = req.Storage.Delete(ctx, connectionStoragePath(name))
 
     if err == nil {
-        b.invalidateMadminClient()
+        b.invalidateMadminClient(name)
         return nil, nil
     }
 
@@ -130,7 +275,8 @@ func (c *Config) Update(d *framework.FieldData) (bool, error) {
 
     changed := false
 
-    keys := []string{"endpoint", "accessKeyId", "secretAccessKey"}
+    keys := []string{"endpoint", "accessKeyId", "secretAccessKey", "sts_endpoint", "region",
+        "ca_cert", "client_cert", "client_key", "tls_server_name", "password_policy", "secret_key_charset"}
 
     for _, key := range keys {
     if v, ok := d.GetOk(key); ok {
@@ -142,13 +288,57 @@ func (c *Config) Update(d *framework.FieldData) (bool, error) {
         c.Configured = true
         changed = true
         case "accessKeyId":
+        if c.AccessKeyId != nv {
+            c.CredentialsLastUpdated = time.Now().UTC()
+            c.PendingSecretAccessKey = ""
+        }
         c.AccessKeyId = nv
         c.Configured = true
         changed = true
         case "secretAccessKey":
+        if c.SecretAccessKey != nv {
+            c.CredentialsLastUpdated = time.Now().UTC()
+            c.PendingSecretAccessKey = ""
+        }
         c.SecretAccessKey = nv
         c.Configured = true
         changed = true
+        case "sts_endpoint":
+        if err := validateStsEndpoint(nv); err != nil {
+            return false, logical.CodedError(400, err.Error())
+        }
+        c.StsEndpoint = nv
+        changed = true
+        case "region":
+        if nv != "" && !regionRegex.MatchString(nv) {
+            return false, logical.CodedError(400, fmt.Sprintf("invalid region %q", nv))
+        }
+        c.Region = nv
+        changed = true
+        case "ca_cert":
+        if nv != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(nv)) {
+            return false, logical.CodedError(400, "ca_cert does not contain any valid PEM certificate")
+        }
+        c.CACert = nv
+        changed = true
+        case "client_cert":
+        c.ClientCert = nv
+        changed = true
+        case "client_key":
+        c.ClientKey = nv
+        changed = true
+        case "tls_server_name":
+        c.TLSServerName = nv
+        changed = true
+        case "password_policy":
+        c.PasswordPolicy = nv
+        changed = true
+        case "secret_key_charset":
+        if err := validateSecretKeyCharset(nv); err != nil {
+            return false, logical.CodedError(400, err.Error())
+        }
+        c.SecretKeyCharset = nv
+        changed = true
         }
     }
     }
@@ -160,13 +350,141 @@ func (c *Config) Update(d *framework.FieldData) (bool, error) {
     changed = true
     }
 
+    if v, ok := d.GetOk("insecure_skip_verify"); ok {
+    c.InsecureSkipVerify = v.(bool)
+    changed = true
+    }
+
+    if v, ok := d.GetOk("secret_key_length"); ok {
+    nv := v.(int)
+    if nv != 0 && (nv < minioSecretKeyMinLength || nv > minioSecretKeyMaxLength) {
+        return false, logical.CodedError(400, fmt.Sprintf("secret_key_length must be between %d and %d", minioSecretKeyMinLength, minioSecretKeyMaxLength))
+    }
+    c.SecretKeyLength = nv
+    changed = true
+    }
+
+    if (c.ClientCert == "") != (c.ClientKey == "") {
+        return false, logical.CodedError(400, "client_cert and client_key must be set together")
+    }
+
+    if c.ClientCert != "" {
+        if _, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey)); err != nil {
+            return false, logical.CodedError(400, fmt.Sprintf("invalid client_cert or client_key: %v", err))
+        }
+    }
+
     return changed, nil
 }
 
+// validateSecretKeyCharset only allows printable ASCII without spaces, and
+// at least two distinct characters
+func validateSecretKeyCharset(charset string) error {
+    if charset == "" {
+        return nil
+    }
+
+    seen := make(map[rune]bool)
+    for _, r := range charset {
+        if r < '!' || r > '~' {
+            return fmt.Errorf("secret_key_charset may only contain printable ASCII characters")
+        }
+        seen[r] = true
+    }
+
+    if len(seen) < 2 {
+        return fmt.Errorf("secret_key_charset must contain at least two distinct characters")
+    }
+
+    return nil
+}
+
+// Transport returns the HTTP transport used to talk to Minio, carrying the
+// configured TLS settings
+func (c *Config) Transport() (*http.Transport, error) {
+    tlsConfig := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        ServerName: c.TLSServerName,
+        InsecureSkipVerify: c.InsecureSkipVerify,
+    }
+
+    if c.CACert != "" {
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+            return nil, errors.New("ca_cert does not contain any valid PEM certificate")
+        }
+        tlsConfig.RootCAs = pool
+    }
+
+    if c.ClientCert != "" {
+        cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+        if err != nil {
+            return nil, fmt.Errorf("invalid client_cert or client_key: %v", err)
+        }
+        tlsConfig.Certificates = []tls.Certificate{cert}
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = tlsConfig
+
+    return transport, nil
+}
+
+// StsEndpointURL returns the URL STS requests are sent to
+func (c *Config) StsEndpointURL() string {
+    scheme := "http"
+    if c.UseSSL {
+        scheme = "https"
+    }
+
+    if c.StsEndpoint == "" {
+        return scheme + "://" + c.Endpoint
+    }
+
+    if strings.Contains(c.StsEndpoint, "://") {
+        return c.StsEndpoint
+    }
+
+    return scheme + "://" + c.StsEndpoint
+}
+
+// validateStsEndpoint accepts an empty value, an http(s) URL or a host[:port]
+func validateStsEndpoint(endpoint string) error {
+    if endpoint == "" {
+        return nil
+    }
+
+    raw := endpoint
+    if !strings.Contains(endpoint, "://") {
+        raw = "//" + endpoint
+    }
+
+    u, err := url.Parse(raw)
+    if err != nil {
+        return fmt.Errorf("invalid sts_endpoint %q: %v", endpoint, err)
+    }
+
+    if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+        return fmt.Errorf("invalid sts_endpoint %q: scheme must be http or https", endpoint)
+    }
+
+    if u.Host == "" {
+        return fmt.Errorf("invalid sts_endpoint %q: missing host", endpoint)
+    }
+
+    return nil
+}
+
+// GetConfig returns the config/root connection
 func (b *minioBackend) GetConfig(ctx context.Context, s logical.Storage) (*Config, error) {
+    return b.GetConnection(ctx, s, "")
+}
+
+// GetConnection returns the named connection, the unnamed one being config/root
+func (b *minioBackend) GetConnection(ctx context.Context, s logical.Storage, name string) (*Config, error) {
     c := DefaultConfig()
 
-    entry, err := s.Get(ctx, configStoragePath);
+    entry, err := s.Get(ctx, connectionStoragePath(name));
     if err != nil {
         return nil, fmt.Errorf("failed to get configuration from backend: %v", err)
     }
@@ -182,6 +500,40 @@ func (b *minioBackend) GetConfig(ctx context.Context, s logical.Storage) (*Confi
     return c, nil
 }
 
+func (b *minioBackend) putConfig(ctx context.Context, s logical.Storage, name string, c *Config) error {
+    // Make a new storage entry
+    entry, err := logical.StorageEntryJSON(connectionStoragePath(name), c)
+    if err != nil {
+        return fmt.Errorf("failed to generate JSON configuration: %v", err)
+    }
+
+    // And store it
+    if err := s.Put(ctx, entry); err != nil {
+        return fmt.Errorf("failed to persist configuration: %v", err)
+    }
+
+    return nil
+}
+
+// connectionStoragePath returns where the named connection is stored
+func connectionStoragePath(name string) string {
+    if name == "" {
+        return configStoragePath
+    }
+
+    return connectionStoragePrefix + name
+}
+
+// connectionName returns the connection a config request targets;
+// config/root has no name field and is the unnamed connection
+func connectionName(d *framework.FieldData) string {
+    if _, ok := d.Schema["name"]; !ok {
+        return ""
+    }
+
+    return d.Get("name").(string)
+}
+
 func DefaultConfig() *Config {
     return &Config{
     Endpoint: "",
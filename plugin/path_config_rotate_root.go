@@ -0,0 +1,227 @@
+package minio
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+)
+
+const (
+    rotateRootPath = "config/rotate-root"
+
+    // Number of attempts made to authenticate with the new admin secret
+    rotateRootVerifyAttempts = 5
+    rotateRootVerifyInterval = time.Second
+)
+
+// Define the rotate-root path
+func (b *minioBackend) pathConfigRotateRoot() *framework.Path {
+    return &framework.Path{
+    Pattern: rotateRootPath,
+    HelpSynopsis: "Rotate the Minio administrative secret access key.",
+    HelpDescription: "Use this endpoint to generate a new secret access key for the configured accessKeyId. The new secret is only stored in Vault and never returned.",
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathConfigRotateRootUpdate,
+        },
+    },
+    }
+}
+
+// walRotateRoot marks a root rotation in progress, whose new secret is
+// kept as the pending secret of config/root
+type walRotateRoot struct {
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// Rotate the administrative secret access key
+func (b *minioBackend) pathConfigRotateRootUpdate(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    b.configMutex.Lock()
+    defer b.configMutex.Unlock()
+
+    c, err := b.GetConfig(ctx, req.Storage)
+    if err != nil {
+        return nil, err
+    }
+
+    if !c.Configured {
+        return logical.ErrorResponse("minio connection is not configured"), logical.ErrInvalidRequest
+    }
+
+    // Settle a rotation interrupted before
+    if c.PendingSecretAccessKey != "" {
+        if err := b.resolvePendingAdminSecret(ctx, req.Storage, c); err != nil {
+            return nil, err
+        }
+    }
+
+    newSecretAccessKey, err := b.generateSecretAccessKey(ctx, c, "")
+    if err != nil {
+        return nil, err
+    }
+
+    // Store the new secret before Minio gets it, so it is never lost
+    walID, err := b.putRotateRootWAL(ctx, req.Storage, &walRotateRoot{CreatedAt: time.Now()})
+    if err != nil {
+        return nil, err
+    }
+    c.PendingSecretAccessKey = newSecretAccessKey
+    if err := b.putConfig(ctx, req.Storage, "", c); err != nil {
+        return nil, err
+    }
+
+    client, err := b.newMadminClient(c)
+    if err != nil {
+        return nil, err
+    }
+
+    oldSecretAccessKey := c.SecretAccessKey
+    if err := b.setAdminSecret(ctx, client, c.AccessKeyId, newSecretAccessKey); err != nil {
+        // Minio may have taken the new secret before failing
+        if resolveErr := b.resolvePendingAdminSecret(ctx, req.Storage, c); resolveErr == nil {
+            b.deleteRotateRootWAL(ctx, req.Storage, walID)
+        }
+        return nil, fmt.Errorf("failed to rotate admin secret access key: %v", err)
+    }
+
+    newConfig := *c
+    newConfig.SecretAccessKey = newSecretAccessKey
+    newConfig.PendingSecretAccessKey = ""
+    newConfig.CredentialsLastUpdated = time.Now().UTC()
+
+    if err := b.verifyAdminSecret(ctx, &newConfig); err != nil {
+        b.Logger().Error("New admin secret access key could not be verified, restoring the previous one", "error", err)
+        if restoreErr := b.restoreAdminSecret(ctx, &newConfig, oldSecretAccessKey); restoreErr != nil {
+            b.Logger().Error("Restoring previous admin secret access key failed", "error", restoreErr)
+            return nil, fmt.Errorf("failed to verify new admin secret access key (%v) and to restore the previous one (%v), the new one is kept pending", err, restoreErr)
+        }
+
+        c.PendingSecretAccessKey = ""
+        if err := b.putConfig(ctx, req.Storage, "", c); err != nil {
+            return nil, err
+        }
+        b.deleteRotateRootWAL(ctx, req.Storage, walID)
+        return nil, fmt.Errorf("failed to verify new admin secret access key: %v", err)
+    }
+
+    if err := b.putConfig(ctx, req.Storage, "", &newConfig); err != nil {
+        return nil, err
+    }
+    b.deleteRotateRootWAL(ctx, req.Storage, walID)
+
+    // Destroy the client still using the old secret
+    b.invalidateMadminClient("")
+
+    return &logical.Response{
+    Data: map[string]interface{}{
+        "accessKeyId": c.AccessKeyId,
+    },
+    }, nil
+}
+
+// restoreAdminSecret sets the previous admin secret back, authenticating
+// with the new one Minio was just given
+func (b *minioBackend) restoreAdminSecret(ctx context.Context, newConfig *Config, oldSecretAccessKey string) error {
+    client, err := b.newMadminClient(newConfig)
+    if err != nil {
+        return err
+    }
+
+    return b.setAdminSecret(ctx, client, newConfig.AccessKeyId, oldSecretAccessKey)
+}
+
+// resolvePendingAdminSecret settles a root rotation which may not have
+// completed: the pending secret replaces the stored one if Minio accepts
+// it, and is dropped if Minio still accepts the stored one
+func (b *minioBackend) resolvePendingAdminSecret(ctx context.Context, s logical.Storage, c *Config) error {
+    pending := *c
+    pending.SecretAccessKey = c.PendingSecretAccessKey
+
+    if err := b.verifyConnection(ctx, &pending); err == nil {
+        b.Logger().Info("Completing interrupted admin secret access key rotation")
+        c.SecretAccessKey = c.PendingSecretAccessKey
+        c.CredentialsLastUpdated = time.Now().UTC()
+    } else if err := b.verifyConnection(ctx, c); err != nil {
+        return fmt.Errorf("neither the stored nor the pending admin secret access key is accepted: %v", err)
+    }
+
+    c.PendingSecretAccessKey = ""
+    if err := b.putConfig(ctx, s, "", c); err != nil {
+        return err
+    }
+
+    b.invalidateMadminClient("")
+    return nil
+}
+
+// rollbackRotateRoot settles a root rotation interrupted before it stored
+// its outcome
+func (b *minioBackend) rollbackRotateRoot(ctx context.Context, req *logical.Request) error {
+    b.configMutex.Lock()
+    defer b.configMutex.Unlock()
+
+    c, err := b.GetConfig(ctx, req.Storage)
+    if err != nil {
+        return err
+    }
+
+    if c.PendingSecretAccessKey == "" {
+        return nil
+    }
+
+    return b.resolvePendingAdminSecret(ctx, req.Storage, c)
+}
+
+// putRotateRootWAL records a pending root rotation
+func (b *minioBackend) putRotateRootWAL(ctx context.Context, s logical.Storage, entry *walRotateRoot) (string, error) {
+    walID, err := framework.PutWAL(ctx, s, walTypeRotateRoot, entry)
+    if err != nil {
+        return "", fmt.Errorf("failed to write WAL entry: %v", err)
+    }
+
+    return walID, nil
+}
+
+// deleteRotateRootWAL removes the WAL entry of a settled root rotation. A
+// leftover entry only makes the rollback find no pending secret.
+func (b *minioBackend) deleteRotateRootWAL(ctx context.Context, s logical.Storage, walID string) {
+    if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+        b.Logger().Warn("Failed to delete root rotation WAL entry", "id", walID, "error", err)
+    }
+}
+
+// setAdminSecret changes the secret of accessKeyId, which may either be a
+// regular Minio user or a service account
+func (b *minioBackend) setAdminSecret(ctx context.Context, client *madmin.AdminClient, accessKeyId string, secretAccessKey string) error {
+    if _, err := client.InfoServiceAccount(ctx, accessKeyId); err == nil {
+        b.Logger().Info("Rotating admin service account secret", "accessKeyId", accessKeyId)
+        return client.UpdateServiceAccount(ctx, accessKeyId, madmin.UpdateServiceAccountReq{
+            NewSecretKey: secretAccessKey,
+        })
+    }
+
+    b.Logger().Info("Rotating admin user secret", "accessKeyId", accessKeyId)
+    return client.SetUser(ctx, accessKeyId, secretAccessKey, madmin.AccountEnabled)
+}
+
+// verifyAdminSecret checks that the given configuration can authenticate,
+// allowing for the change to propagate through the Minio cluster
+func (b *minioBackend) verifyAdminSecret(ctx context.Context, c *Config) error {
+    for attempt := 1; ; attempt++ {
+        err := b.verifyConnection(ctx, c)
+        if err == nil || attempt == rotateRootVerifyAttempts {
+            return err
+        }
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(rotateRootVerifyInterval):
+        }
+    }
+}
@@ -0,0 +1,185 @@
+package minio_test
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/stretchr/testify/require"
+)
+
+const (
+    rotateRootPath = "config/rotate-root"
+)
+
+func TestConfigRotateRootError(t *testing.T) {
+    t.Run("Test Rotate Root When Not Configured", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        resp, err := testConfigRotateRoot(t, s)
+
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+    })
+
+    t.Run("Test Rotate Root When Minio Is Unreachable Keeps Old Secret", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+            "endpoint":        "127.0.0.1:1",
+            "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
+        })
+        require.NoError(t, err)
+
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+
+        b, _ := getMinioBackend(t)
+        _, err = b.HandleRequest(ctx, &logical.Request{
+            Operation: logical.UpdateOperation,
+            Path:      rotateRootPath,
+            Storage:   s,
+        })
+        require.Error(t, err)
+
+        entry, err := s.Get(context.Background(), configStoragePath)
+        require.NoError(t, err)
+        var config map[string]interface{}
+        require.NoError(t, entry.DecodeJSON(&config))
+        require.Equal(t, TEST_APP_OSS_SECRET_ACCESS_KEY, config["secretAccessKey"])
+    })
+}
+
+func TestConfigRotateRoot(t *testing.T) {
+    server := newTestMinioServer(t)
+    server.authenticate = true
+    s := new(logical.InmemStorage)
+    server.configure(t, s)
+
+    b, err := getMinioBackend(t)
+    require.NoError(t, err)
+
+    request := func(op logical.Operation, path string) (*logical.Response, error) {
+        return b.HandleRequest(context.Background(), &logical.Request{
+            ID:        generateRandomString(),
+            Operation: op,
+            Path:      path,
+            Storage:   s,
+        })
+    }
+
+    // Caches a client signing with the current secret
+    _, err = request(logical.ListOperation, "policies")
+    require.NoError(t, err)
+
+    _, err = request(logical.UpdateOperation, rotateRootPath)
+    require.NoError(t, err)
+
+    entry, err := s.Get(context.Background(), configStoragePath)
+    require.NoError(t, err)
+    var config map[string]interface{}
+    require.NoError(t, entry.DecodeJSON(&config))
+    require.NotEqual(t, TEST_APP_OSS_SECRET_ACCESS_KEY, config["secretAccessKey"])
+    require.Equal(t, server.currentAdminSecret(), config["secretAccessKey"])
+    require.NotContains(t, config, "pending_secret_access_key")
+
+    // The old client was dropped, the next one signs with the new secret
+    _, err = request(logical.ListOperation, "policies")
+    require.NoError(t, err)
+
+    wals, err := framework.ListWAL(context.Background(), s)
+    require.NoError(t, err)
+    require.Empty(t, wals)
+}
+
+func TestConfigRotateRootConcurrent(t *testing.T) {
+    server := newTestMinioServer(t)
+    server.authenticate = true
+    s := new(logical.InmemStorage)
+    server.configure(t, s)
+
+    b, err := getMinioBackend(t)
+    require.NoError(t, err)
+
+    // Rotations and connection writes racing each other all complete
+    var wg sync.WaitGroup
+    errs := make(chan error, 8)
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            req := &logical.Request{
+                ID:        generateRandomString(),
+                Operation: logical.UpdateOperation,
+                Path:      rotateRootPath,
+                Storage:   s,
+            }
+            if i%4 == 3 {
+                req.Path = "config/root"
+                req.Data = map[string]interface{}{"region": "us-east-1"}
+            }
+            _, err := b.HandleRequest(context.Background(), req)
+            errs <- err
+        }(i)
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        require.NoError(t, err)
+    }
+
+    entry, err := s.Get(context.Background(), configStoragePath)
+    require.NoError(t, err)
+    var config map[string]interface{}
+    require.NoError(t, entry.DecodeJSON(&config))
+    require.Equal(t, server.currentAdminSecret(), config["secretAccessKey"])
+    require.NotContains(t, config, "pending_secret_access_key")
+
+    wals, err := framework.ListWAL(context.Background(), s)
+    require.NoError(t, err)
+    require.Empty(t, wals)
+}
+
+func TestConfigRotateRootRollback(t *testing.T) {
+    server := newTestMinioServer(t)
+    server.authenticate = true
+    s := new(logical.InmemStorage)
+    server.configure(t, s)
+
+    // As if a rotation stopped once Minio had the pending secret
+    entry, err := s.Get(context.Background(), configStoragePath)
+    require.NoError(t, err)
+    var config map[string]interface{}
+    require.NoError(t, entry.DecodeJSON(&config))
+    config["pending_secret_access_key"] = "pendingSecret"
+    entry, err = logical.StorageEntryJSON(configStoragePath, config)
+    require.NoError(t, err)
+    require.NoError(t, s.Put(context.Background(), entry))
+    _, err = framework.PutWAL(context.Background(), s, "rotateRoot", map[string]interface{}{
+        "created_at": time.Now(),
+    })
+    require.NoError(t, err)
+    server.setAdminSecret("pendingSecret")
+
+    _, err = testRollback(t, s)
+    require.NoError(t, err)
+
+    entry, err = s.Get(context.Background(), configStoragePath)
+    require.NoError(t, err)
+    config = nil
+    require.NoError(t, entry.DecodeJSON(&config))
+    require.Equal(t, "pendingSecret", config["secretAccessKey"])
+    require.NotContains(t, config, "pending_secret_access_key")
+}
+
+func testConfigRotateRoot(t *testing.T, s logical.Storage) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.UpdateOperation,
+        Path:      rotateRootPath,
+        Storage:   s,
+    })
+}
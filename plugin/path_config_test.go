@@ -2,11 +2,21 @@ package minio_test
 
 import (
     "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
     "fmt"
+    "math/big"
+    "strings"
     "testing"
+    "time"
 
     "github.com/hashicorp/vault/sdk/logical"
     "github.com/stretchr/testify/require"
+    minio "github.com/jayxiong1/vault-plugin-secrets-minio/plugin"
 )
 
 const (
@@ -15,6 +25,7 @@ const (
     TEST_APP_OSS_SECRET_ACCESS_KEY = "test-secret-access-key"
     TEST_OSS_ENDPOINT_USE_SSL      = true
     configStoragePath = "config/root"
+    TEST_ANY_TIMESTAMP = "<any timestamp>"
 )
 
 func TestConfigSuccess(t *testing.T) {
@@ -30,12 +41,7 @@ func TestConfigSuccess(t *testing.T) {
 
         require.NoError(t, err)
 
-        err = testConfigRead(t, reqStorage, map[string]interface{}{
-            "endpoint":        TEST_APP_OSS_ENDPOINT,
-            "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
-            "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
-            "useSSL":          TEST_OSS_ENDPOINT_USE_SSL,
-        })
+        err = testConfigRead(t, reqStorage, testExpectedConfig(TEST_APP_OSS_ENDPOINT, TEST_APP_OSS_ACCESS_KEY_ID, TEST_APP_OSS_SECRET_ACCESS_KEY, TEST_OSS_ENDPOINT_USE_SSL))
 
         require.NoError(t, err)
 
@@ -49,32 +55,258 @@ func TestConfigSuccess(t *testing.T) {
 
         require.NoError(t, err)
 
-        err = testConfigRead(t, reqStorage, map[string]interface{}{
+        err = testConfigRead(t, reqStorage, testExpectedConfig(TEST_APP_OSS_ENDPOINT, "new-access-kye-id", "new-secret-access-key", TEST_OSS_ENDPOINT_USE_SSL))
+
+        require.NoError(t, err)
+
+        resp, err := testConfigDelete(t, reqStorage)
+
+        require.NoError(t, err)
+        require.Nil(t, resp.Error())
+    })
+
+    t.Run("Test Plugin Configuration Delete Error When In Use", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, reqStorage, map[string]interface{}{
             "endpoint":        TEST_APP_OSS_ENDPOINT,
-            "accessKeyId":     "new-access-kye-id",
-            "secretAccessKey": "new-secret-access-key",
+            "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
             "useSSL":          TEST_OSS_ENDPOINT_USE_SSL,
         })
+        require.NoError(t, err)
 
+        _, err = testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "policy_name":     TEST_POLICY_NAME,
+            "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+        })
         require.NoError(t, err)
 
         resp, err := testConfigDelete(t, reqStorage)
+        require.Equal(t, logical.ErrInvalidRequest, err)
+        require.True(t, resp.IsError())
 
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+
+        resp, err = testConfigDelete(t, reqStorage)
         require.NoError(t, err)
         require.Nil(t, resp.Error())
     })
 }
 
+func TestConfigReadRedactsSecret(t *testing.T) {
+    t.Run("Test Plugin Configuration Read Does Not Return Secret", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+            "endpoint":        TEST_APP_OSS_ENDPOINT,
+            "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
+        })
+        require.NoError(t, err)
+
+        b, _ := getMinioBackend(t)
+        resp, err := b.HandleRequest(context.Background(), &logical.Request{
+            Operation: logical.ReadOperation,
+            Path:      configStoragePath,
+            Storage:   s,
+        })
+        require.NoError(t, err)
+        require.NotContains(t, resp.Data, "secretAccessKey")
+        for _, v := range resp.Data {
+            require.NotEqual(t, TEST_APP_OSS_SECRET_ACCESS_KEY, v)
+        }
+    })
+
+    t.Run("Test Plugin Configuration Read Of Legacy Configuration", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        entry, err := logical.StorageEntryJSON(configStoragePath, map[string]interface{}{
+            "endpoint":        TEST_APP_OSS_ENDPOINT,
+            "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "useSSL":          TEST_OSS_ENDPOINT_USE_SSL,
+            "is_configured":   true,
+        })
+        require.NoError(t, err)
+        require.NoError(t, s.Put(context.Background(), entry))
+
+        expected := testExpectedConfig(TEST_APP_OSS_ENDPOINT, TEST_APP_OSS_ACCESS_KEY_ID, TEST_APP_OSS_SECRET_ACCESS_KEY, TEST_OSS_ENDPOINT_USE_SSL)
+        expected["credentials_last_updated"] = ""
+        err = testConfigRead(t, s, expected)
+        require.NoError(t, err)
+    })
+}
+
+func TestConfigStsSettings(t *testing.T) {
+    t.Run("Test Plugin Configuration Rejects Invalid STS Settings", func(t *testing.T) {
+        for _, d := range []map[string]interface{}{
+            {"sts_endpoint": "ftp://sts.example.com"},
+            {"sts_endpoint": "https://"},
+            {"region": "us east 1"},
+        } {
+            s := new(logical.InmemStorage)
+            err := testConfigCreateOrUpdate(t, s, d)
+            require.Error(t, err)
+        }
+    })
+
+    t.Run("Test Plugin Configuration Stores STS Settings", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+            "endpoint":        TEST_APP_OSS_ENDPOINT,
+            "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "useSSL":          TEST_OSS_ENDPOINT_USE_SSL,
+            "sts_endpoint":    "sts.oss-test.com:9000",
+            "region":          "us-east-1",
+        })
+        require.NoError(t, err)
+
+        expected := testExpectedConfig(TEST_APP_OSS_ENDPOINT, TEST_APP_OSS_ACCESS_KEY_ID, TEST_APP_OSS_SECRET_ACCESS_KEY, TEST_OSS_ENDPOINT_USE_SSL)
+        expected["sts_endpoint"] = "sts.oss-test.com:9000"
+        expected["region"] = "us-east-1"
+        err = testConfigRead(t, s, expected)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test STS Endpoint URL", func(t *testing.T) {
+        c := minio.Config{Endpoint: TEST_APP_OSS_ENDPOINT}
+        require.Equal(t, "http://"+TEST_APP_OSS_ENDPOINT, c.StsEndpointURL())
+
+        c.UseSSL = true
+        require.Equal(t, "https://"+TEST_APP_OSS_ENDPOINT, c.StsEndpointURL())
+
+        c.StsEndpoint = "sts.oss-test.com:9000"
+        require.Equal(t, "https://sts.oss-test.com:9000", c.StsEndpointURL())
+
+        c.StsEndpoint = "http://sts.oss-test.com:9000"
+        require.Equal(t, "http://sts.oss-test.com:9000", c.StsEndpointURL())
+    })
+}
+
+func TestConfigTLSSettings(t *testing.T) {
+    certPEM, keyPEM := testGenerateCertificate(t)
+
+    t.Run("Test Plugin Configuration Rejects Invalid TLS Settings", func(t *testing.T) {
+        for _, d := range []map[string]interface{}{
+            {"ca_cert": "not a certificate"},
+            {"client_cert": certPEM},
+            {"client_key": keyPEM},
+            {"client_cert": certPEM, "client_key": "not a key"},
+        } {
+            s := new(logical.InmemStorage)
+            err := testConfigCreateOrUpdate(t, s, d)
+            require.Error(t, err)
+        }
+    })
+
+    t.Run("Test Plugin Configuration Stores TLS Settings", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+            "endpoint":             TEST_APP_OSS_ENDPOINT,
+            "accessKeyId":          TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey":      TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "useSSL":               TEST_OSS_ENDPOINT_USE_SSL,
+            "ca_cert":              certPEM,
+            "client_cert":          certPEM,
+            "client_key":           keyPEM,
+            "tls_server_name":      "minio.internal",
+            "insecure_skip_verify": true,
+        })
+        require.NoError(t, err)
+
+        expected := testExpectedConfig(TEST_APP_OSS_ENDPOINT, TEST_APP_OSS_ACCESS_KEY_ID, TEST_APP_OSS_SECRET_ACCESS_KEY, TEST_OSS_ENDPOINT_USE_SSL)
+        expected["ca_cert"] = strings.TrimSpace(certPEM)
+        expected["client_cert"] = strings.TrimSpace(certPEM)
+        expected["client_key_set"] = true
+        expected["tls_server_name"] = "minio.internal"
+        expected["insecure_skip_verify"] = true
+        err = testConfigRead(t, s, expected)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Transport Carries TLS Settings", func(t *testing.T) {
+        c := minio.Config{
+            CACert:             certPEM,
+            ClientCert:         certPEM,
+            ClientKey:          keyPEM,
+            TLSServerName:      "minio.internal",
+            InsecureSkipVerify: true,
+        }
+        transport, err := c.Transport()
+        require.NoError(t, err)
+        require.NotNil(t, transport.TLSClientConfig.RootCAs)
+        require.Len(t, transport.TLSClientConfig.Certificates, 1)
+        require.Equal(t, "minio.internal", transport.TLSClientConfig.ServerName)
+        require.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+    })
+}
+
+func TestConfigSecretKeySettings(t *testing.T) {
+    t.Run("Test Plugin Configuration Rejects Invalid Secret Key Settings", func(t *testing.T) {
+        for _, d := range []map[string]interface{}{
+            {"secret_key_length": 4},
+            {"secret_key_length": 64},
+            {"secret_key_charset": "a"},
+            {"secret_key_charset": "ab cd"},
+            {"password_policy": "missing-policy"},
+        } {
+            s := new(logical.InmemStorage)
+            err := testConfigCreateOrUpdate(t, s, d)
+            require.Error(t, err)
+        }
+    })
+
+    t.Run("Test Plugin Configuration Stores Secret Key Settings", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+            "endpoint":           TEST_APP_OSS_ENDPOINT,
+            "accessKeyId":        TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey":    TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "useSSL":             TEST_OSS_ENDPOINT_USE_SSL,
+            "secret_key_length":  20,
+            "secret_key_charset": "abc123",
+        })
+        require.NoError(t, err)
+
+        expected := testExpectedConfig(TEST_APP_OSS_ENDPOINT, TEST_APP_OSS_ACCESS_KEY_ID, TEST_APP_OSS_SECRET_ACCESS_KEY, TEST_OSS_ENDPOINT_USE_SSL)
+        expected["secret_key_length"] = 20
+        expected["secret_key_charset"] = "abc123"
+        err = testConfigRead(t, s, expected)
+        require.NoError(t, err)
+    })
+}
+
+func TestConfigVerifyConnection(t *testing.T) {
+    t.Run("Test Plugin Configuration Rejected When Minio Is Unreachable", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+            "endpoint":          "127.0.0.1:1",
+            "accessKeyId":       TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey":   TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "verify_connection": true,
+        })
+        require.Error(t, err)
+
+        entry, err := s.Get(context.Background(), configStoragePath)
+        require.NoError(t, err)
+        require.Nil(t, entry)
+    })
+
+    t.Run("Test Plugin Configuration Rejected When Incomplete", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+        err := testConfigCreateOrUpdate(t, s, map[string]interface{}{
+            "endpoint":          TEST_APP_OSS_ENDPOINT,
+            "verify_connection": true,
+        })
+        require.Error(t, err)
+    })
+}
+
 func TestConfigReadError(t *testing.T) {
     reqStorage := new(logical.InmemStorage)
     
     t.Run("Test Plugin Configuration Reading Empty Configuration", func(t *testing.T) {
-        err := testConfigRead(t, reqStorage, map[string]interface{}{
-            "endpoint":        TEST_APP_OSS_ENDPOINT,
-            "accessKeyId":     "new-access-kye-id",
-            "secretAccessKey": "new-secret-access-key",
-            "useSSL":          TEST_OSS_ENDPOINT_USE_SSL,
-        })
+        err := testConfigRead(t, reqStorage, testExpectedConfig(TEST_APP_OSS_ENDPOINT, "new-access-kye-id", "new-secret-access-key", TEST_OSS_ENDPOINT_USE_SSL))
 
         require.Error(t, err)
     })
@@ -176,6 +408,50 @@ func TestConfigDeleteError(t *testing.T) {
     })
 }
 
+func testGenerateCertificate(t *testing.T) (string, string) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    require.NoError(t, err)
+
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "minio.internal"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    require.NoError(t, err)
+
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    require.NoError(t, err)
+
+    certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+    keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+    return string(certPEM), string(keyPEM)
+}
+
+func testExpectedConfig(endpoint string, accessKeyId string, secretAccessKey string, useSSL bool) map[string]interface{} {
+    return map[string]interface{}{
+        "endpoint":                      endpoint,
+        "accessKeyId":                   accessKeyId,
+        "useSSL":                        useSSL,
+        "secret_access_key_set":         true,
+        "credentials_last_updated":      TEST_ANY_TIMESTAMP,
+        "sts_endpoint":                  "",
+        "region":                        "",
+        "ca_cert":                       "",
+        "client_cert":                   "",
+        "client_key_set":                false,
+        "tls_server_name":               "",
+        "insecure_skip_verify":          false,
+        "password_policy":               "",
+        "secret_key_length":             0,
+        "secret_key_charset":            "",
+    }
+}
+
 func testConfigDelete(t *testing.T, s logical.Storage) (*logical.Response, error) {
     b, _ := getMinioBackend(t)
     return b.HandleRequest(context.Background(), &logical.Request{
@@ -186,6 +462,11 @@ func testConfigDelete(t *testing.T, s logical.Storage) (*logical.Response, error
 }
 
 func testConfigCreateOrUpdate(t *testing.T, s logical.Storage, d map[string]interface{}) error {
+    // There is no Minio server to verify against unless a test asks for it
+    if _, ok := d["verify_connection"]; !ok {
+        d["verify_connection"] = false
+    }
+
     b, _ := getMinioBackend(t)
     resp, err := b.HandleRequest(context.Background(), &logical.Request{
         Operation: logical.UpdateOperation,
@@ -223,6 +504,14 @@ func testConfigRead(t *testing.T, s logical.Storage, expected map[string]interfa
     for key, expectedVal := range expected {
         actualVal, _ := resp.Data[key]
 
+        if expectedVal == TEST_ANY_TIMESTAMP {
+            timestamp, _ := actualVal.(string)
+            if _, err := time.Parse(time.RFC3339, timestamp); err != nil {
+                return fmt.Errorf(`expected data["%s"] to be a timestamp, instead got %v`, key, actualVal)
+            }
+            continue
+        }
+
         if expectedVal != actualVal {
             return fmt.Errorf(`expected data["%s"] = %v, instead got %v"`, key, actualVal, actualVal)
         }
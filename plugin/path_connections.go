@@ -0,0 +1,150 @@
+package minio
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+)
+
+// List the named connections
+func (b *minioBackend) pathConnections() *framework.Path {
+    return &framework.Path{
+    Pattern: "config/connections/?$",
+    HelpSynopsis: "List configured Minio connections.",
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ListOperation: &framework.PathOperation{
+            Callback: b.pathConnectionsList,
+        },
+    },
+    }
+}
+
+// pathConnectionsList lists the currently defined connections
+func (b *minioBackend) pathConnectionsList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    connections, err := req.Storage.List(ctx, connectionStoragePrefix)
+    if err != nil {
+        return nil, fmt.Errorf("unable to retrieve list of connections: %v", err)
+    }
+
+    return logical.ListResponse(connections), nil
+}
+
+// Define the CRUD functions for named connections, sharing the config/root handlers
+func (b *minioBackend) pathConnectionsCRUD() *framework.Path {
+    fields := configFields()
+    fields["name"] = &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Connection name.",
+    }
+
+    return &framework.Path{
+    Pattern: "config/connections/" + framework.GenericNameRegex("name"),
+    HelpSynopsis: "Configure a named Minio connection.",
+    HelpDescription: "Use this endpoint to manage additional Minio connections which roles can select with their connection field.",
+
+    Fields: fields,
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathConfigRead,
+        },
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathConfigUpdate,
+        },
+        logical.DeleteOperation: &framework.PathOperation{
+            Callback: b.pathConfigDelete,
+        },
+    },
+    }
+}
+
+// connectionOwner describes what still uses the named connection, or
+// config/root if name is empty: a role, a static role, a library set, or a
+// user issued on it which was not removed yet. It returns an empty string if
+// nothing does.
+func (b *minioBackend) connectionOwner(ctx context.Context, s logical.Storage, name string) (string, error) {
+    roles, err := b.ListRoles(ctx, s)
+    if err != nil {
+        return "", err
+    }
+
+    for _, roleName := range roles {
+        r, err := b.GetRole(ctx, s, roleName)
+        if err != nil {
+            return "", err
+        }
+
+        if r.Connection == name {
+            return fmt.Sprintf("role %q", roleName), nil
+        }
+    }
+
+    staticRoles, err := s.List(ctx, staticRoleStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list static roles: %v", err)
+    }
+
+    for _, roleName := range staticRoles {
+        role, err := b.getStaticRole(ctx, s, roleName)
+        if err != nil {
+            return "", err
+        }
+        if role != nil && role.Connection == name {
+            return fmt.Sprintf("static role %q", roleName), nil
+        }
+    }
+
+    sets, err := s.List(ctx, libraryStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list library sets: %v", err)
+    }
+
+    for _, setName := range sets {
+        set, err := b.getLibrarySet(ctx, s, setName)
+        if err != nil {
+            return "", err
+        }
+        if set != nil && set.Connection == name {
+            return fmt.Sprintf("library set %q", setName), nil
+        }
+
+        users, err := b.getLibraryUsers(ctx, s, setName)
+        if err != nil {
+            return "", err
+        }
+        for _, user := range users {
+            if user.Connection == name {
+                return fmt.Sprintf("user %q of library set %q", user.AccessKeyID, setName), nil
+            }
+        }
+    }
+
+    if err := b.migrateLegacyUserCreds(ctx, s); err != nil {
+        return "", err
+    }
+
+    userRoles, err := s.List(ctx, userStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list users from persistent storage: %v", err)
+    }
+
+    for _, roleName := range userRoles {
+        roleName = strings.TrimSuffix(roleName, "/")
+
+        users, err := b.getRoleUserCreds(ctx, s, roleName)
+        if err != nil {
+            return "", err
+        }
+        for _, userCreds := range users {
+            if userCreds.Connection == name {
+                return fmt.Sprintf("user %q of role %q", userCreds.AccessKeyID, roleName), nil
+            }
+        }
+    }
+
+    return "", nil
+}
@@ -0,0 +1,185 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/stretchr/testify/require"
+)
+
+const (
+    TEST_CONNECTION_NAME = "test-connection"
+)
+
+func TestPluginConnections(t *testing.T) {
+    reqStorage := new(logical.InmemStorage)
+
+    t.Run("Test Connection Apis With No Error", func(t *testing.T) {
+        resp, err := testConnectionRequest(t, reqStorage, logical.UpdateOperation, TEST_CONNECTION_NAME, map[string]interface{}{
+            "endpoint":          TEST_APP_OSS_ENDPOINT,
+            "accessKeyId":       TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey":   TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "useSSL":            TEST_OSS_ENDPOINT_USE_SSL,
+            "verify_connection": false,
+        })
+        require.NoError(t, err)
+        require.Nil(t, resp)
+
+        resp, err = testConnectionRequest(t, reqStorage, logical.ReadOperation, TEST_CONNECTION_NAME, nil)
+        require.NoError(t, err)
+        require.Equal(t, TEST_APP_OSS_ENDPOINT, resp.Data["endpoint"])
+        require.Equal(t, TEST_APP_OSS_ACCESS_KEY_ID, resp.Data["accessKeyId"])
+        require.NotContains(t, resp.Data, "secretAccessKey")
+
+        resp, err = testConnectionList(t, reqStorage)
+        require.NoError(t, err)
+        require.Equal(t, []string{TEST_CONNECTION_NAME}, resp.Data["keys"])
+
+        // The root configuration is left untouched
+        b, _ := getMinioBackend(t)
+        resp, err = b.HandleRequest(context.Background(), &logical.Request{
+            Operation: logical.ReadOperation,
+            Path:      configStoragePath,
+            Storage:   reqStorage,
+        })
+        require.NoError(t, err)
+        require.Equal(t, "", resp.Data["endpoint"])
+
+        _, err = testConnectionRequest(t, reqStorage, logical.DeleteOperation, TEST_CONNECTION_NAME, nil)
+        require.NoError(t, err)
+
+        resp, err = testConnectionRequest(t, reqStorage, logical.ReadOperation, TEST_CONNECTION_NAME, nil)
+        require.NoError(t, err)
+        require.Nil(t, resp)
+    })
+
+    t.Run("Test Role Using Connection", func(t *testing.T) {
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+            "connection":       TEST_CONNECTION_NAME,
+        })
+        require.Error(t, err)
+
+        _, err = testConnectionRequest(t, reqStorage, logical.UpdateOperation, TEST_CONNECTION_NAME, map[string]interface{}{
+            "endpoint":          TEST_APP_OSS_ENDPOINT,
+            "accessKeyId":       TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey":   TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "verify_connection": false,
+        })
+        require.NoError(t, err)
+
+        _, err = testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+            "connection":       TEST_CONNECTION_NAME,
+        })
+        require.NoError(t, err)
+
+        resp, err := testRoleRead(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        require.Equal(t, TEST_CONNECTION_NAME, resp.Data["connection"])
+
+        // A connection used by a role cannot be deleted
+        _, err = testConnectionRequest(t, reqStorage, logical.DeleteOperation, TEST_CONNECTION_NAME, nil)
+        require.Error(t, err)
+
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+
+        _, err = testConnectionRequest(t, reqStorage, logical.DeleteOperation, TEST_CONNECTION_NAME, nil)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Connection Used By Static Roles, Library Sets And Users", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        _, err := testConnectionRequest(t, reqStorage, logical.UpdateOperation, TEST_CONNECTION_NAME, map[string]interface{}{
+            "endpoint":        server.endpoint(),
+            "accessKeyId":     TEST_APP_OSS_ACCESS_KEY_ID,
+            "secretAccessKey": TEST_APP_OSS_SECRET_ACCESS_KEY,
+            "useSSL":          false,
+        })
+        require.NoError(t, err)
+
+        // Static role
+        server.addUser(TEST_STATIC_USERNAME, "initialSecret")
+        _, err = testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "username":        TEST_STATIC_USERNAME,
+            "rotation_period": "1h",
+            "connection":      TEST_CONNECTION_NAME,
+        })
+        require.NoError(t, err)
+        resp, err := testConnectionRequest(t, reqStorage, logical.DeleteOperation, TEST_CONNECTION_NAME, nil)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        _, err = testStaticRoleRequest(t, reqStorage, logical.DeleteOperation, TEST_STATIC_ROLE_NAME, nil)
+        require.NoError(t, err)
+
+        // Library set
+        _, err = testLibraryRequest(t, reqStorage, logical.CreateOperation, TEST_LIBRARY_SET, nil, map[string]interface{}{
+            "users":       TEST_LIBRARY_CREATED_USER,
+            "policy_name": TEST_POLICY_NAME,
+            "connection":  TEST_CONNECTION_NAME,
+        })
+        require.NoError(t, err)
+        resp, err = testConnectionRequest(t, reqStorage, logical.DeleteOperation, TEST_CONNECTION_NAME, nil)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        _, err = testLibraryRequest(t, reqStorage, logical.DeleteOperation, TEST_LIBRARY_SET, nil, nil)
+        require.NoError(t, err)
+
+        // User issued before its role moved to another connection
+        _, err = testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "policy_name":     TEST_POLICY_NAME,
+            "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+            "connection":      TEST_CONNECTION_NAME,
+        })
+        require.NoError(t, err)
+        resp, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        accessKeyId := resp.Data["accessKeyId"].(string)
+
+        _, err = testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "connection": "",
+        })
+        require.NoError(t, err)
+        resp, err = testConnectionRequest(t, reqStorage, logical.DeleteOperation, TEST_CONNECTION_NAME, nil)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        _, err = testRevokeRequest(t, reqStorage, map[string]interface{}{
+            "access_key_id": accessKeyId,
+            "connection":    TEST_CONNECTION_NAME,
+        })
+        require.NoError(t, err)
+        _, err = testConnectionRequest(t, reqStorage, logical.DeleteOperation, TEST_CONNECTION_NAME, nil)
+        require.NoError(t, err)
+    })
+}
+
+func testConnectionList(t *testing.T, s logical.Storage) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.ListOperation,
+        Path:      "config/connections/",
+        Storage:   s,
+    })
+}
+
+func testConnectionRequest(t *testing.T, s logical.Storage, op logical.Operation, name string, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: op,
+        Path:      "config/connections/" + name,
+        Data:      d,
+        Storage:   s,
+    })
+}
@@ -44,67 +44,167 @@ func (b *minioBackend) pathKeysCreate(ctx context.Context, req *logical.Request,
     now := time.Now()
     roleName := d.Get("role").(string)
 
+    // Only one request per role may decide whether a new user is needed
+    lock := b.roleLock(roleName)
+    lock.Lock()
+
     role, err := b.GetRole(ctx, req.Storage, roleName)
     if err != nil {
+        lock.Unlock()
         return nil, fmt.Errorf("error fetching role: %v", err)
     }
 
-    userCreds, err := b.getActiveUserCreds(ctx, req, roleName, role, now)
+    // Per entity roles need an entity to issue users to
+    if _, err := userEntityID(req, role); err != nil {
+        lock.Unlock()
+        return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+    }
+
+    // Service accounts are issued one per lease and don't share a user
+    if role.CredentialType == ServiceAccountCredentialType {
+        lock.Unlock()
+        return b.pathKeysCreateServiceAccount(ctx, req, roleName, role, now)
+    }
+
+    // STS credentials need a user outliving them to be issued from
+    var stsTTL time.Duration
+    var warnings []string
+    if role.CredentialType == StsCredentialType {
+        stsTTL, warnings = roleStsTTL(role, time.Duration(d.Get("ttl").(int))*time.Second)
+    }
+
+    userCreds, err := b.getActiveUserCreds(ctx, req, roleName, role, stsTTL, now)
+    if err == nil && role.CredentialType == StaticCredentialType {
+        // Each static lease holds on to the shared user until revoked
+        userCreds.Leases++
+        err = b.putUserCreds(ctx, req.Storage, roleName, userCreds)
+    }
+    lock.Unlock()
     if err != nil {
         return nil, err
     }
 
     credentialType := role.CredentialType
-    var resp map[string]interface{}
+    var resp *logical.Response
 
     switch credentialType {
     case StaticCredentialType:
-        resp = map[string]interface{}{
-            "accessKeyId":     		userCreds.AccessKeyID,
-            "secretAccessKey": 		userCreds.SecretAccessKey,
-            "policy_name":     		role.PolicyName,
-            "ttl":             		userCreds.ExpirationDate.Format(time.DateTime),
-            "userAccountStatus": 	userCreds.Status,
-        }
+        resp = b.Secret(secretStaticType).Response(map[string]interface{}{
+            "accessKeyId":          userCreds.AccessKeyID,
+            "secretAccessKey":      userCreds.SecretAccessKey,
+            "policy_name":          role.PolicyName,
+            "userAccountStatus":    userCreds.Status,
+        }, map[string]interface{}{
+            "role":        roleName,
+            "accessKeyId": userCreds.AccessKeyID,
+            "entityId":    userCreds.EntityID,
+        })
+
+        // Every lease on this role shares the same user, so none may outlive it
+        ttl := userCreds.ExpirationDate.Sub(now)
+        resp.Secret.TTL = ttl
+        resp.Secret.MaxTTL = ttl
     case StsCredentialType:
-        var sts_ttl int
-        ttl := int(d.Get("ttl").(int))
-        maxTtl := int(role.MaxStsTTL.Seconds())
-    
-        if ttl == 0 || ttl > maxTtl {
-            sts_ttl = maxTtl
-        } else {
-            sts_ttl = ttl
-        }
-        newKey, err := b.getSTS(ctx, req, userCreds, role.PolicyDocument, sts_ttl)
+        newKey, err := b.getSTS(ctx, req, userCreds, role.PolicyDocument, int(stsTTL.Seconds()))
         if err != nil {
             return nil, err
         }
-        resp = map[string]interface{}{
+        resp = b.Secret(secretStsType).Response(map[string]interface{}{
             "accessKeyId":     newKey.AccessKeyID,
             "secretAccessKey": newKey.SecretAccessKey,
-            "sessionToken":	   newKey.SessionToken,
-            "ttl":             newKey.Expiration.Format(time.DateTime),
+            "sessionToken":    newKey.SessionToken,
+        }, map[string]interface{}{
+            "role":        roleName,
+            "accessKeyId": newKey.AccessKeyID,
+        })
+
+        // STS credentials cannot be extended once issued. The lease follows
+        // the expiration Minio gave them, which the client may have raised,
+        // but not beyond the user they were issued from.
+        ttl := stsTTL
+        if !newKey.Expiration.IsZero() {
+            ttl = newKey.Expiration.Sub(now).Truncate(time.Second)
+        }
+        if remaining := userCreds.ExpirationDate.Sub(now); remaining < ttl {
+            ttl = remaining
         }
+        resp.Secret.TTL = ttl
+        resp.Secret.MaxTTL = ttl
+        for _, warning := range warnings {
+            resp.AddWarning(warning)
+        }
+    default:
+        return logical.ErrorResponse("unsupported credential type %q", credentialType), nil
     }
-    
 
-    return &logical.Response{
-        Data: resp,
-    }, nil
+    return resp, nil
+}
+
+// roleStsTTL returns the lifetime of STS credentials requested with ttl,
+// defaulting to and capped by the role's max_sts_ttl and raised to the
+// minimum Minio accepts
+func roleStsTTL(role *Role, ttl time.Duration) (time.Duration, []string) {
+    if ttl <= 0 || ttl > role.MaxStsTTL {
+        return role.MaxStsTTL, nil
+    }
+
+    if ttl < minStsTTL {
+        return minStsTTL, []string{fmt.Sprintf("ttl raised to the minimum of %s for sts credentials", minStsTTL)}
+    }
+
+    return ttl, nil
+}
+
+// pathKeysCreateServiceAccount issues a new service account for the role
+func (b *minioBackend) pathKeysCreateServiceAccount(ctx context.Context, req *logical.Request, roleName string, role *Role, now time.Time) (*logical.Response, error) {
+    creds, err := b.addServiceAccount(ctx, req, role, roleName, now)
+    if err != nil {
+        return nil, err
+    }
+
+    resp := b.Secret(secretServiceAccountType).Response(map[string]interface{}{
+        "accessKeyId":     creds.AccessKey,
+        "secretAccessKey": creds.SecretKey,
+        "expiration":      creds.Expiration.Format(time.RFC3339),
+    }, map[string]interface{}{
+        "role":        roleName,
+        "accessKeyId": creds.AccessKey,
+        "connection":  role.Connection,
+    })
+
+    // Minio expires the service account on its own, the lease must not outlive it
+    resp.Secret.TTL = role.MaxTTL
+    resp.Secret.MaxTTL = role.MaxTTL
+
+    return resp, nil
 }
 
 func (b *minioBackend) pathKeysRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
     roleName := d.Get("role").(string)
+
+    lock := b.roleLock(roleName)
+    lock.Lock()
+    defer lock.Unlock()
+
     r, err := b.GetRole(ctx, req.Storage, roleName)
     if err != nil {
         return nil, err
     }
-    oldestCreds, err := b.getOldestUserCreds(ctx, req, roleName)
+    // Per entity roles only revoke the caller's own users
+    entityID, err := userEntityID(req, r)
+    if err != nil {
+        return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+    }
+    users, err := b.getEntityUserCreds(ctx, req.Storage, roleName, entityID)
     if err != nil {
         return nil, err
     }
-    err = b.removeUser(ctx, req, r, roleName, oldestCreds)
+    users = enabledUserCreds(users)
+    if len(users) == 0 {
+        return nil, fmt.Errorf("no credentials issued for role %s", roleName)
+    }
+    oldestCreds := oldestUserCreds(users)
+    err = b.revokeUser(ctx, req, r, roleName, oldestCreds)
     if err != nil {
         return nil, err
     }
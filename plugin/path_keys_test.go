@@ -3,6 +3,7 @@ package minio_test
 import (
 	"context"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
@@ -81,6 +82,704 @@ func TestPluginPathKeysRevokeError(t *testing.T) {
     })
 }
 
+func TestPluginUserStorageMigration(t *testing.T) {
+    t.Run("Test Legacy User Map Migrated To Per Role Entries", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        expiration := time.Now().Add(time.Hour)
+        userMap := map[string][]minio.UserInfo{
+            TEST_ROLE_NAME: {{
+                AccessKeyID:     "userAccesskey",
+                SecretAccessKey: "secretAccessKey",
+                PolicyName:      TEST_POLICY_NAME,
+                Status:          madmin.AccountEnabled,
+                ExpirationDate:  expiration,
+            }},
+            "other-role": {{
+                AccessKeyID:     "otherAccesskey",
+                SecretAccessKey: "otherSecretAccessKey",
+                PolicyName:      TEST_POLICY_NAME,
+                Status:          madmin.AccountEnabled,
+                ExpirationDate:  expiration,
+            }},
+        }
+        entry, err := logical.StorageEntryJSON(userStoragePath, userMap)
+        require.NoError(t, err)
+        require.NoError(t, reqStorage.Put(context.Background(), entry))
+
+        // Renewing a lease is the cheapest way to touch user storage without Minio
+        _, err = testSecretRenew(t, reqStorage, TEST_SECRET_STATIC_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "userAccesskey",
+        })
+        require.NoError(t, err)
+
+        entry, err = reqStorage.Get(context.Background(), userStoragePath)
+        require.NoError(t, err)
+        require.Nil(t, entry)
+
+        keys, err := reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/")
+        require.NoError(t, err)
+        require.Equal(t, []string{"userAccesskey"}, keys)
+
+        keys, err = reqStorage.List(context.Background(), userStoragePath+"/other-role/")
+        require.NoError(t, err)
+        require.Equal(t, []string{"otherAccesskey"}, keys)
+
+        var userCreds minio.UserInfo
+        entry, err = reqStorage.Get(context.Background(), userStoragePath+"/other-role/otherAccesskey")
+        require.NoError(t, err)
+        require.NoError(t, entry.DecodeJSON(&userCreds))
+        require.Equal(t, "otherSecretAccessKey", userCreds.SecretAccessKey)
+    })
+
+    t.Run("Test Path Keys Api Revoke Error When Role Has No Credentials", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testPathKeysRevoke(t, reqStorage, TEST_ROLE_NAME)
+        require.Error(t, err)
+        require.Nil(t, resp)
+    })
+}
+
+func TestPluginPathKeysConcurrency(t *testing.T) {
+    const workers = 50
+
+    t.Run("Test Concurrent Static Credential Requests Share One User", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        b, _ := getMinioBackend(t)
+        accessKeys := make([]string, workers)
+        errs := make([]error, workers)
+
+        var wg sync.WaitGroup
+        for i := 0; i < workers; i++ {
+            wg.Add(1)
+            go func(i int, id string) {
+                defer wg.Done()
+                resp, err := b.HandleRequest(context.Background(), &logical.Request{
+                    ID:        id,
+                    Operation: logical.ReadOperation,
+                    Path:      "creds/" + TEST_ROLE_NAME,
+                    Storage:   reqStorage,
+                })
+                if err == nil {
+                    accessKeys[i] = resp.Data["accessKeyId"].(string)
+                }
+                errs[i] = err
+            }(i, generateRandomString())
+        }
+        wg.Wait()
+
+        for i := 0; i < workers; i++ {
+            require.NoError(t, errs[i])
+            require.Equal(t, accessKeys[0], accessKeys[i])
+        }
+        require.Equal(t, 1, server.addUserCalls)
+        require.Equal(t, 1, server.userCount())
+    })
+
+    t.Run("Test Concurrent Issuance And Role Deletion Leave No Orphans", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        b, _ := getMinioBackend(t)
+
+        var wg sync.WaitGroup
+        for i := 0; i < workers; i++ {
+            wg.Add(1)
+            go func(i int, id string) {
+                defer wg.Done()
+                req := &logical.Request{
+                    ID:        id,
+                    Operation: logical.ReadOperation,
+                    Path:      "creds/" + TEST_ROLE_NAME,
+                    Storage:   reqStorage,
+                }
+                if i == workers/2 {
+                    req.Operation = logical.DeleteOperation
+                    req.Path = "roles/" + TEST_ROLE_NAME
+                }
+                b.HandleRequest(context.Background(), req)
+            }(i, generateRandomString())
+        }
+        wg.Wait()
+
+        // Whatever the interleaving, Minio and Vault storage must agree
+        keys, err := reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/")
+        require.NoError(t, err)
+        require.Equal(t, len(keys), server.userCount())
+        for _, key := range keys {
+            require.True(t, server.hasUser(key))
+        }
+    })
+}
+
+func TestPluginPathKeysUserNameTemplate(t *testing.T) {
+    t.Run("Test Path Keys Api Renders Username Template", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":              TEST_ROLE_NAME,
+            "username_template": `{{ printf "%s-%s-%s" .RoleName .DisplayName (random 8) | truncate 32 | lowercase }}`,
+            "policy_name":       TEST_POLICY_NAME,
+            "credential_type":   TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        b, _ := getMinioBackend(t)
+        resp, err := b.HandleRequest(context.Background(), &logical.Request{
+            ID:          generateRandomString(),
+            DisplayName: "Token-App",
+            Operation:   logical.ReadOperation,
+            Path:        "creds/" + TEST_ROLE_NAME,
+            Storage:     reqStorage,
+        })
+        require.NoError(t, err)
+
+        accessKeyId := resp.Data["accessKeyId"].(string)
+        require.Regexp(t, "^test-role-name-token-app-[a-z0-9]{7}$", accessKeyId)
+        require.True(t, server.hasUser(accessKeyId))
+    })
+
+    t.Run("Test Path Keys Api Rejects Invalid Rendered Name", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":              TEST_ROLE_NAME,
+            "username_template": "{{ .RoleName }} with {{ .RequestID }}",
+            "policy_name":       TEST_POLICY_NAME,
+            "credential_type":   TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        _, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.Error(t, err)
+        require.Equal(t, 0, server.addUserCalls)
+    })
+
+    t.Run("Test Path Keys Api Rejects Rendered Name Already Taken", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":              TEST_ROLE_NAME,
+            "username_template": "{{ .DisplayName }}{{ .RequestID }}",
+            "policy_name":       TEST_POLICY_NAME,
+            "credential_type":   TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        issue := func(displayName string, requestID string) error {
+            b, _ := getMinioBackend(t)
+            _, err := b.HandleRequest(context.Background(), &logical.Request{
+                ID:          requestID,
+                DisplayName: displayName,
+                Operation:   logical.ReadOperation,
+                Path:        "creds/" + TEST_ROLE_NAME,
+                Storage:     reqStorage,
+            })
+            return err
+        }
+
+        // Any Minio user, the admin user included
+        server.addUser("existing-user", "existingSecret")
+        require.Error(t, issue("existing-", "user"))
+        require.Equal(t, "existingSecret", server.secretKey("existing-user"))
+        require.Error(t, issue(TEST_APP_OSS_ACCESS_KEY_ID[:2], TEST_APP_OSS_ACCESS_KEY_ID[2:]))
+        require.Equal(t, 0, server.addUserCalls)
+    })
+}
+
+func TestPluginPathKeysSecretKeyGeneration(t *testing.T) {
+    sys := logical.TestSystemView()
+    sys.SetPasswordPolicy("config-policy", func() (string, error) {
+        return "configPolicySecret", nil
+    })
+    sys.SetPasswordPolicy("role-policy", func() (string, error) {
+        return "rolePolicySecret", nil
+    })
+    b, err := getMinioBackendWithSystemView(t, sys)
+    require.NoError(t, err)
+
+    issue := func(t *testing.T, configData map[string]interface{}, roleData map[string]interface{}) string {
+        t.Helper()
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        configData["verify_connection"] = false
+        _, err := b.HandleRequest(context.Background(), &logical.Request{
+            Operation: logical.UpdateOperation,
+            Path:      "config/root",
+            Data:      configData,
+            Storage:   reqStorage,
+        })
+        require.NoError(t, err)
+
+        roleData["policy_name"] = TEST_POLICY_NAME
+        roleData["credential_type"] = TEST_STATIC_CREDENTIAL_TYPE
+        _, err = b.HandleRequest(context.Background(), &logical.Request{
+            Operation: logical.CreateOperation,
+            Path:      "roles/" + TEST_ROLE_NAME,
+            Data:      roleData,
+            Storage:   reqStorage,
+        })
+        require.NoError(t, err)
+
+        resp, err := b.HandleRequest(context.Background(), &logical.Request{
+            ID:        generateRandomString(),
+            Operation: logical.ReadOperation,
+            Path:      "creds/" + TEST_ROLE_NAME,
+            Storage:   reqStorage,
+        })
+        require.NoError(t, err)
+
+        secretAccessKey := resp.Data["secretAccessKey"].(string)
+        require.Equal(t, secretAccessKey, server.users[resp.Data["accessKeyId"].(string)].SecretKey)
+        return secretAccessKey
+    }
+
+    t.Run("Test Secret Key From Config Password Policy", func(t *testing.T) {
+        secret := issue(t, map[string]interface{}{"password_policy": "config-policy"}, map[string]interface{}{})
+        require.Equal(t, "configPolicySecret", secret)
+    })
+
+    t.Run("Test Secret Key From Role Password Policy", func(t *testing.T) {
+        secret := issue(t, map[string]interface{}{"password_policy": "config-policy"}, map[string]interface{}{
+            "password_policy": "role-policy",
+        })
+        require.Equal(t, "rolePolicySecret", secret)
+    })
+
+    t.Run("Test Secret Key From Built-in Generator", func(t *testing.T) {
+        secret := issue(t, map[string]interface{}{"secret_key_length": 12, "secret_key_charset": "xyz"}, map[string]interface{}{})
+        require.Regexp(t, "^[xyz]{12}$", secret)
+
+        secret = issue(t, map[string]interface{}{"secret_key_length": 16}, map[string]interface{}{})
+        require.Regexp(t, "^[a-zA-Z0-9]{16}$", secret)
+    })
+
+    t.Run("Test Role Write Error With Unknown Password Policy", func(t *testing.T) {
+        resp, err := b.HandleRequest(context.Background(), &logical.Request{
+            Operation: logical.CreateOperation,
+            Path:      "roles/" + TEST_ROLE_NAME,
+            Data: map[string]interface{}{
+                "policy_name":     TEST_POLICY_NAME,
+                "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+                "password_policy": "missing-policy",
+            },
+            Storage: new(logical.InmemStorage),
+        })
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+    })
+}
+
+func TestPluginPathKeysInlinePolicy(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "policy_name":      TEST_POLICY_NAME,
+        "policy_document":  TEST_POLICY_DOCUMENT,
+        "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    accessKeyId := resp.Data["accessKeyId"].(string)
+
+    // The user gets a policy of its own named after it
+    require.JSONEq(t, TEST_POLICY_DOCUMENT, string(server.cannedPolicies[accessKeyId]))
+    require.ElementsMatch(t, []string{TEST_POLICY_NAME, accessKeyId}, server.policies[accessKeyId])
+
+    _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, resp.Secret.InternalData)
+    require.NoError(t, err)
+    require.False(t, server.hasUser(accessKeyId))
+    require.NotContains(t, server.cannedPolicies, accessKeyId)
+}
+
+func TestPluginPathKeysPoliciesAndGroups(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "policy_name":      TEST_POLICY_NAME,
+        "policy_names":     "readonly,diagnostics",
+        "groups":           "apps, auditors",
+        "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    accessKeyId := resp.Data["accessKeyId"].(string)
+
+    require.ElementsMatch(t, []string{TEST_POLICY_NAME, "readonly", "diagnostics"}, server.policies[accessKeyId])
+    require.True(t, server.groups["apps"][accessKeyId])
+    require.True(t, server.groups["auditors"][accessKeyId])
+
+    // Removal undoes what was done at creation even once the role changed
+    _, err = testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "policy_name":      TEST_POLICY_NAME,
+        "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, resp.Secret.InternalData)
+    require.NoError(t, err)
+    require.False(t, server.hasUser(accessKeyId))
+    require.False(t, server.groups["apps"][accessKeyId])
+    require.False(t, server.groups["auditors"][accessKeyId])
+}
+
+func TestPluginPathKeysServiceAccount(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "parent_user":      TEST_PARENT_USER,
+        "policy_document":  TEST_POLICY_DOCUMENT,
+        "max_ttl":          TEST_MAX_TTL,
+        "credential_type":  TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    t.Run("Test Path Keys Api Generate Service Account", func(t *testing.T) {
+        resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        require.NotNil(t, resp.Secret)
+        require.Equal(t, 720*time.Hour, resp.Secret.TTL)
+        require.Equal(t, "serviceAccountSecret", resp.Data["secretAccessKey"])
+
+        accessKeyId := resp.Data["accessKeyId"].(string)
+        require.LessOrEqual(t, len(accessKeyId), 20)
+
+        sa, ok := server.serviceAccount(accessKeyId)
+        require.True(t, ok)
+        require.Equal(t, TEST_PARENT_USER, sa.TargetUser)
+        require.Equal(t, "vault-"+TEST_ROLE_NAME, sa.Name)
+        require.Regexp(t, "^Issued by Vault for role "+TEST_ROLE_NAME+", request .+$", sa.Description)
+        require.JSONEq(t, TEST_POLICY_DOCUMENT, string(sa.Policy))
+        require.WithinDuration(t, time.Now().Add(720*time.Hour), *sa.Expiration, time.Minute)
+
+        // Service accounts are not shared between leases
+        resp, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        require.NotEqual(t, accessKeyId, resp.Data["accessKeyId"])
+
+        // No WAL entry is left behind
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        _, ok = server.serviceAccount(accessKeyId)
+        require.True(t, ok)
+    })
+
+    t.Run("Test Service Account Lease Revoke", func(t *testing.T) {
+        resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        accessKeyId := resp.Data["accessKeyId"].(string)
+
+        for i := 0; i < 2; i++ {
+            _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_SERVICE_ACCOUNT_TYPE, resp.Secret.InternalData)
+            require.NoError(t, err)
+            _, ok := server.serviceAccount(accessKeyId)
+            require.False(t, ok)
+        }
+    })
+
+    t.Run("Test Service Account Name Within Minio Bounds", func(t *testing.T) {
+        roleName := "team.analytics.service-account.reporting"
+        _, err := testRoleCreateOrUpdate(t, reqStorage, roleName, map[string]interface{}{
+            "parent_user":      TEST_PARENT_USER,
+            "max_ttl":          TEST_MAX_TTL,
+            "credential_type":  TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, roleName)
+        require.NoError(t, err)
+
+        sa, ok := server.serviceAccount(resp.Data["accessKeyId"].(string))
+        require.True(t, ok)
+        require.Equal(t, "vault-team-analytics-service-acc", sa.Name)
+        require.Contains(t, sa.Description, roleName)
+    })
+}
+
+func TestPluginPathKeysPerEntity(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "policy_name":      TEST_POLICY_NAME,
+        "per_entity":       true,
+        "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    issue := func(t *testing.T, entityID string) (*logical.Response, error) {
+        t.Helper()
+        b, _ := getMinioBackend(t)
+        return b.HandleRequest(context.Background(), &logical.Request{
+            ID:        generateRandomString(),
+            Operation: logical.ReadOperation,
+            Path:      "creds/" + TEST_ROLE_NAME,
+            Storage:   reqStorage,
+            EntityID:  entityID,
+        })
+    }
+
+    first, err := issue(t, "entity-1")
+    require.NoError(t, err)
+    firstKey := first.Data["accessKeyId"].(string)
+
+    // The same entity keeps getting its own user
+    again, err := issue(t, "entity-1")
+    require.NoError(t, err)
+    require.Equal(t, firstKey, again.Data["accessKeyId"])
+
+    second, err := issue(t, "entity-2")
+    require.NoError(t, err)
+    secondKey := second.Data["accessKeyId"].(string)
+    require.NotEqual(t, firstKey, secondKey)
+    require.Equal(t, 2, server.userCount())
+    require.ElementsMatch(t, []string{TEST_POLICY_NAME}, server.policies[secondKey])
+
+    // Each entity's users are stored apart
+    keys, err := reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/")
+    require.NoError(t, err)
+    require.Equal(t, []string{"entity-1/", "entity-2/"}, keys)
+    keys, err = reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/entity-2/")
+    require.NoError(t, err)
+    require.Equal(t, []string{secondKey}, keys)
+
+    // Requests without an entity have no user to be given
+    resp, err := issue(t, "")
+    require.Equal(t, logical.ErrInvalidRequest, err)
+    require.True(t, resp.IsError())
+
+    b, _ := getMinioBackend(t)
+    resp, err = b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.DeleteOperation,
+        Path:      "creds/" + TEST_ROLE_NAME,
+        Storage:   reqStorage,
+    })
+    require.Equal(t, logical.ErrInvalidRequest, err)
+    require.True(t, resp.IsError())
+
+    // Revoking one entity's leases leaves the other's user in place
+    _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, first.Secret.InternalData)
+    require.NoError(t, err)
+    require.True(t, server.hasUser(firstKey))
+    _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, again.Secret.InternalData)
+    require.NoError(t, err)
+    require.False(t, server.hasUser(firstKey))
+    require.True(t, server.hasUser(secondKey))
+
+    // A revoked entity is issued a new user of its own
+    third, err := issue(t, "entity-1")
+    require.NoError(t, err)
+    require.NotEqual(t, firstKey, third.Data["accessKeyId"])
+    require.True(t, server.hasUser(secondKey))
+
+    // Users are still found by access key alone, as with leases issued
+    // before the entity was recorded
+    delete(second.Secret.InternalData, "entityId")
+    _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, second.Secret.InternalData)
+    require.NoError(t, err)
+    require.False(t, server.hasUser(secondKey))
+
+    _, err = testRevokeRequest(t, reqStorage, map[string]interface{}{
+        "access_key_id": third.Data["accessKeyId"],
+    })
+    require.NoError(t, err)
+    require.False(t, server.hasUser(third.Data["accessKeyId"].(string)))
+}
+
+func TestPluginPathKeysRotationOverlap(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "policy_name":      TEST_POLICY_NAME,
+        "max_ttl":          "1h",
+        "rotation_overlap": "10m",
+        "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    first, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    firstKey := first.Data["accessKeyId"].(string)
+
+    again, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    require.Equal(t, firstKey, again.Data["accessKeyId"])
+
+    // Within the overlap a new user is issued, the old one staying valid
+    testExpireUser(t, reqStorage, firstKey, time.Now().Add(5*time.Minute))
+    second, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    secondKey := second.Data["accessKeyId"].(string)
+    require.NotEqual(t, firstKey, secondKey)
+    require.Equal(t, madmin.AccountEnabled, server.userStatus(firstKey))
+    require.Equal(t, madmin.AccountEnabled, server.userStatus(secondKey))
+
+    again, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    require.Equal(t, secondKey, again.Data["accessKeyId"])
+
+    // Once expired the old user is disabled before it is removed
+    testExpireUser(t, reqStorage, firstKey, time.Now().Add(-time.Minute))
+    _, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    require.Equal(t, madmin.AccountDisabled, server.userStatus(firstKey))
+    require.Equal(t, madmin.AccountEnabled, server.userStatus(secondKey))
+
+    _, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    require.False(t, server.hasUser(firstKey))
+    require.True(t, server.hasUser(secondKey))
+}
+
+func TestPluginPathKeysStsTTL(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "policy_name":     TEST_POLICY_NAME,
+        "max_sts_ttl":     "2h",
+        "credential_type": TEST_STS_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    // Too short for Minio, the lease follows the credentials actually issued
+    resp, err := testPathKeysCreateStsCredentials(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "ttl": TEST_STS_TTL,
+    })
+    require.NoError(t, err)
+    require.NotEmpty(t, resp.Warnings)
+    require.GreaterOrEqual(t, server.stsDurations[0], 900)
+    require.InDelta(t, float64(server.stsDurations[0]), resp.Secret.TTL.Seconds(), 5)
+
+    resp, err = testPathKeysCreateStsCredentials(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "ttl": "90m",
+    })
+    require.NoError(t, err)
+    require.Empty(t, resp.Warnings)
+    require.Equal(t, 5400, server.stsDurations[1])
+    require.InDelta(t, float64(5400), resp.Secret.TTL.Seconds(), 5)
+
+    // Capped by the role
+    resp, err = testPathKeysCreateStsCredentials(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "ttl": "3h",
+    })
+    require.NoError(t, err)
+    require.Equal(t, 7200, server.stsDurations[2])
+    require.InDelta(t, float64(7200), resp.Secret.TTL.Seconds(), 5)
+}
+
+func TestPluginPathKeysStsParentUser(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "policy_name":     TEST_POLICY_NAME,
+        "max_sts_ttl":     "2h",
+        "max_ttl":         "3h",
+        "credential_type": TEST_STS_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    _, err = testPathKeysCreateStsCredentials(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "ttl": "90m",
+    })
+    require.NoError(t, err)
+    require.Equal(t, 1, server.userCount())
+    keys, err := reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/")
+    require.NoError(t, err)
+    firstParent := keys[0]
+
+    // A parent user expiring before the credentials is replaced, but kept
+    // for the credentials it issued already
+    testExpireUser(t, reqStorage, firstParent, time.Now().Add(time.Hour))
+    resp, err := testPathKeysCreateStsCredentials(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "ttl": "90m",
+    })
+    require.NoError(t, err)
+    require.Equal(t, 2, server.userCount())
+    require.True(t, server.hasUser(firstParent))
+    require.InDelta(t, float64(5400), resp.Secret.TTL.Seconds(), 5)
+
+    // The lease never outlives the parent user, even if Minio issued the
+    // credentials for longer
+    keys, err = reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/")
+    require.NoError(t, err)
+    for _, key := range keys {
+        if key != firstParent {
+            testExpireUser(t, reqStorage, key, time.Now().Add(30*time.Minute))
+        }
+    }
+    testExpireUser(t, reqStorage, firstParent, time.Now().Add(10*time.Minute))
+    resp, err = testPathKeysCreateStsCredentials(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "ttl": "15m",
+    })
+    require.NoError(t, err)
+    require.Equal(t, 2, server.userCount())
+    require.Greater(t, server.stsDurations[2], 1800)
+    require.InDelta(t, float64(1800), resp.Secret.TTL.Seconds(), 5)
+}
+
 func testPathKeysCreateStaticCredentials(t *testing.T, s logical.Storage, roleName string) (*logical.Response, error) {
     t.Helper()
     b, _ := getMinioBackend(t)
@@ -0,0 +1,976 @@
+package minio
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    uuid "github.com/hashicorp/go-uuid"
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+    libraryStoragePrefix     = "library/"
+    libraryUserStoragePrefix = "library-users/"
+)
+
+// LibrarySet is a set of Minio users lent out through check-outs
+type LibrarySet struct {
+    // Users are the access keys of the set, checked out in this order
+    Users []string `json:"users"`
+
+    // PolicyName, PolicyNames and Groups are given to the users the plugin
+    // creates, users which already existed keep their own
+    PolicyName  string   `json:"policy_name"`
+    PolicyNames []string `json:"policy_names"`
+    Groups      []string `json:"groups"`
+
+    // PasswordPolicy is the Vault password policy generating secret keys,
+    // overriding the connection's
+    PasswordPolicy string `json:"password_policy"`
+
+    // Connection is the named Minio connection of the users, empty for
+    // config/root
+    Connection string `json:"connection"`
+
+    // TTL is the default lifetime of a check-out, MaxTTL its longest
+    TTL    time.Duration `json:"ttl"`
+    MaxTTL time.Duration `json:"max_ttl"`
+
+    // DisableCheckInEnforcement lets anyone check in a user, not only its
+    // borrower
+    DisableCheckInEnforcement bool `json:"disable_check_in_enforcement"`
+}
+
+// LibraryUser is the state of a user of a library set
+type LibraryUser struct {
+    AccessKeyID     string `json:"access_key_id"`
+    SecretAccessKey string `json:"secret_access_key"`
+
+    // Created is set for users the plugin created, and removes along with
+    // the set. PolicyName, PolicyNames and Groups record what was given to
+    // them.
+    Created     bool     `json:"created"`
+    PolicyName  string   `json:"policy_name,omitempty"`
+    PolicyNames []string `json:"policy_names,omitempty"`
+    Groups      []string `json:"groups,omitempty"`
+
+    Connection string `json:"connection,omitempty"`
+
+    // Available is false while the user is checked out
+    Available bool `json:"available"`
+
+    // CheckOutID identifies the current check-out, so that the lease of an
+    // earlier one cannot check the user in
+    CheckOutID          string `json:"check_out_id,omitempty"`
+    BorrowerEntityID    string `json:"borrower_entity_id,omitempty"`
+    BorrowerClientToken string `json:"borrower_client_token,omitempty"`
+}
+
+// List the library sets
+func (b *minioBackend) pathLibrary() *framework.Path {
+    return &framework.Path{
+    Pattern: "library/?$",
+    HelpSynopsis: "List library sets.",
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ListOperation: &framework.PathOperation{
+            Callback: b.pathLibraryList,
+        },
+    },
+    }
+}
+
+// Define the CRUD functions for library sets
+func (b *minioBackend) pathLibraryCRUD() *framework.Path {
+    return &framework.Path{
+    Pattern: libraryStoragePrefix + framework.GenericNameRegex("name"),
+    HelpSynopsis: "Manage a library set of Minio users.",
+    HelpDescription: "Use this endpoint to define a set of Minio users lent out through check-outs. Users which do not exist in Minio are created, users which do have their secret key taken over by Vault.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Library set name.",
+        },
+        "users": &framework.FieldSchema{
+        Type: framework.TypeCommaStringSlice,
+        Description: "Access keys of the Minio users of the set, checked out in this order.",
+        },
+        "policy_name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Minio policy name attached to the users created by the plugin.",
+        },
+        "policy_names": &framework.FieldSchema{
+        Type: framework.TypeCommaStringSlice,
+        Description: "Additional Minio policy names attached to the users created by the plugin.",
+        },
+        "groups": &framework.FieldSchema{
+        Type: framework.TypeCommaStringSlice,
+        Description: "Minio groups the users created by the plugin are added to.",
+        },
+        "password_policy": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Vault password policy generating secret keys. Defaults to the connection's.",
+        },
+        "connection": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Name of the Minio connection of the users. Defaults to config/root. Cannot be changed once set.",
+        },
+        "ttl": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Default: "24h",
+        Description: "Default lifetime of a check-out.",
+        },
+        "max_ttl": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Default: "24h",
+        Description: "Maximum lifetime of a check-out, renewals included.",
+        },
+        "disable_check_in_enforcement": &framework.FieldSchema{
+        Type: framework.TypeBool,
+        Description: "Allow anyone to check in a user, not only its borrower.",
+        },
+    },
+
+    ExistenceCheck: b.pathLibraryExistsCheck,
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.CreateOperation: &framework.PathOperation{
+            Callback: b.pathLibraryWrite,
+        },
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathLibraryRead,
+        },
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathLibraryWrite,
+        },
+        logical.DeleteOperation: &framework.PathOperation{
+            Callback: b.pathLibraryDelete,
+        },
+    },
+    }
+}
+
+// Define the check-out path of library sets
+func (b *minioBackend) pathLibraryCheckOut() *framework.Path {
+    return &framework.Path{
+    Pattern: libraryStoragePrefix + framework.GenericNameRegex("name") + "/check-out$",
+    HelpSynopsis: "Check out a user of a library set.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Library set name.",
+        },
+        "ttl": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Description: "Lifetime of the check-out, at most the ttl of the set.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathLibraryCheckOutUpdate,
+        },
+    },
+    }
+}
+
+// Define the check-in path of library sets
+func (b *minioBackend) pathLibraryCheckIn() *framework.Path {
+    return &framework.Path{
+    Pattern: libraryStoragePrefix + framework.GenericNameRegex("name") + "/check-in$",
+    HelpSynopsis: "Check in users of a library set.",
+    HelpDescription: "Use this endpoint to check in users before their check-out expires. Their secret key is rotated. Defaults to the users checked out by the caller.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Library set name.",
+        },
+        "users": &framework.FieldSchema{
+        Type: framework.TypeCommaStringSlice,
+        Description: "Access keys of the users to check in.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathLibraryCheckInUpdate,
+        },
+    },
+    }
+}
+
+// Define the status path of library sets
+func (b *minioBackend) pathLibraryStatus() *framework.Path {
+    return &framework.Path{
+    Pattern: libraryStoragePrefix + framework.GenericNameRegex("name") + "/status$",
+    HelpSynopsis: "Report which users of a library set are available.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Library set name.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathLibraryStatusRead,
+        },
+    },
+    }
+}
+
+// pathLibraryList lists the library sets
+func (b *minioBackend) pathLibraryList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    names, err := req.Storage.List(ctx, libraryStoragePrefix)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list library sets: %v", err)
+    }
+
+    return logical.ListResponse(names), nil
+}
+
+// pathLibraryExistsCheck checks to see if a library set exists
+func (b *minioBackend) pathLibraryExistsCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+    set, err := b.getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+    if err != nil {
+        return false, err
+    }
+
+    return set != nil, nil
+}
+
+// pathLibraryRead returns a library set
+func (b *minioBackend) pathLibraryRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    set, err := b.getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+    if err != nil {
+        return nil, err
+    }
+
+    if set == nil {
+        return nil, nil
+    }
+
+    return &logical.Response{
+    Data: map[string]interface{}{
+        "users": set.Users,
+        "policy_name": set.PolicyName,
+        "policy_names": set.PolicyNames,
+        "groups": set.Groups,
+        "password_policy": set.PasswordPolicy,
+        "connection": set.Connection,
+        "ttl": int64(set.TTL.Seconds()),
+        "max_ttl": int64(set.MaxTTL.Seconds()),
+        "disable_check_in_enforcement": set.DisableCheckInEnforcement,
+    },
+    }, nil
+}
+
+// pathLibraryWrite creates a library set or updates the supplied fields of
+// an existing one, taking over the users added and releasing the removed
+func (b *minioBackend) pathLibraryWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    lock := b.libraryLock(name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    set, err := b.getLibrarySet(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    create := set == nil
+    if create {
+        set = &LibrarySet{
+            Connection: strings.TrimSpace(d.Get("connection").(string)),
+        }
+    } else if v, ok := d.GetOk("connection"); ok && strings.TrimSpace(v.(string)) != set.Connection {
+        return logical.ErrorResponse("connection cannot be changed"), logical.ErrInvalidRequest
+    }
+
+    // get returns the supplied value of key, or its default on create
+    get := func(key string) (interface{}, bool) {
+        if v, ok := d.GetOk(key); ok {
+            return v, true
+        }
+        if create {
+            return d.Get(key), true
+        }
+        return nil, false
+    }
+
+    if v, ok := get("users"); ok {
+        set.Users = uniqueStrings(trimStrings(v.([]string)))
+    }
+    if v, ok := get("policy_name"); ok {
+        set.PolicyName = strings.TrimSpace(v.(string))
+    }
+    if v, ok := get("policy_names"); ok {
+        set.PolicyNames = trimStrings(v.([]string))
+    }
+    if v, ok := get("groups"); ok {
+        set.Groups = trimStrings(v.([]string))
+    }
+    if v, ok := get("password_policy"); ok {
+        set.PasswordPolicy = strings.TrimSpace(v.(string))
+    }
+    if v, ok := get("ttl"); ok {
+        set.TTL = time.Duration(v.(int)) * time.Second
+    }
+    if v, ok := get("max_ttl"); ok {
+        set.MaxTTL = time.Duration(v.(int)) * time.Second
+    }
+    if v, ok := get("disable_check_in_enforcement"); ok {
+        set.DisableCheckInEnforcement = v.(bool)
+    }
+
+    if len(set.Users) == 0 {
+        return logical.ErrorResponse("users is required"), logical.ErrInvalidRequest
+    }
+    for _, username := range set.Users {
+        if err := validateUserName(username); err != nil {
+            return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+        }
+    }
+    if set.TTL <= 0 || set.MaxTTL <= 0 {
+        return logical.ErrorResponse("ttl and max_ttl must be positive"), logical.ErrInvalidRequest
+    }
+    if set.TTL > set.MaxTTL {
+        return logical.ErrorResponse("ttl must not exceed max_ttl"), logical.ErrInvalidRequest
+    }
+
+    if err := b.validatePasswordPolicy(ctx, set.PasswordPolicy); err != nil {
+        return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+    }
+
+    c, err := b.GetConnection(ctx, req.Storage, set.Connection)
+    if err != nil {
+        return nil, err
+    }
+    if set.Connection != "" && !c.Configured {
+        return logical.ErrorResponse("connection %q does not exist", set.Connection), logical.ErrInvalidRequest
+    }
+
+    users, err := b.getLibraryUsers(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    // Check every change before making any
+    var added []string
+    for _, username := range set.Users {
+        if _, ok := users[username]; ok {
+            continue
+        }
+
+        // Rotating the admin user would lock the plugin out
+        if username == c.AccessKeyId {
+            return logical.ErrorResponse("user %q is the connection's admin user", username), logical.ErrInvalidRequest
+        }
+        if owner, err := b.staticRoleOfUser(ctx, req.Storage, set.Connection, username); err != nil {
+            return nil, err
+        } else if owner != "" {
+            return logical.ErrorResponse("user %q is already managed by static role %q", username, owner), logical.ErrInvalidRequest
+        }
+        if owner, err := b.librarySetOfUser(ctx, req.Storage, set.Connection, username); err != nil {
+            return nil, err
+        } else if owner != "" {
+            return logical.ErrorResponse("user %q is already in library set %q", username, owner), logical.ErrInvalidRequest
+        }
+        if owner, err := b.roleOfIssuedUser(ctx, req.Storage, set.Connection, username); err != nil {
+            return nil, err
+        } else if owner != "" {
+            return logical.ErrorResponse("user %q was issued by role %q", username, owner), logical.ErrInvalidRequest
+        }
+
+        added = append(added, username)
+    }
+
+    var removed []*LibraryUser
+    for username, user := range users {
+        if stringInSlice(username, set.Users) {
+            continue
+        }
+        if !user.Available {
+            return logical.ErrorResponse("user %q is checked out and cannot be removed from the set", username), logical.ErrInvalidRequest
+        }
+        removed = append(removed, user)
+    }
+
+    for _, user := range removed {
+        if err := b.releaseLibraryUser(ctx, req.Storage, name, user); err != nil {
+            return nil, err
+        }
+    }
+
+    // The set is stored before any user is taken over, so a user whose
+    // secret key changed always belongs to a stored set. Users a failed
+    // request did not get to are taken over by the next write.
+    entry, err := logical.StorageEntryJSON(libraryStoragePrefix+name, set)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create storage entry: %v", err)
+    }
+
+    if err := req.Storage.Put(ctx, entry); err != nil {
+        return nil, fmt.Errorf("failed to write entry to storage: %v", err)
+    }
+
+    for _, username := range added {
+        if err := b.takeOverLibraryUser(ctx, req.Storage, name, set, username); err != nil {
+            return nil, err
+        }
+    }
+
+    return nil, nil
+}
+
+// pathLibraryDelete deletes a library set whose users are all checked in,
+// removing the users the plugin created
+func (b *minioBackend) pathLibraryDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    lock := b.libraryLock(name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    users, err := b.getLibraryUsers(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    for username, user := range users {
+        if !user.Available {
+            return logical.ErrorResponse("user %q is checked out, check it in before deleting the set", username), logical.ErrInvalidRequest
+        }
+    }
+
+    for _, user := range users {
+        if err := b.releaseLibraryUser(ctx, req.Storage, name, user); err != nil {
+            return nil, err
+        }
+    }
+
+    if err := req.Storage.Delete(ctx, libraryStoragePrefix+name); err != nil {
+        return nil, fmt.Errorf("failed to delete library set: %v", err)
+    }
+
+    return nil, nil
+}
+
+// pathLibraryCheckOutUpdate lends the first available user of a set
+func (b *minioBackend) pathLibraryCheckOutUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    lock := b.libraryLock(name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    set, err := b.getLibrarySet(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    if set == nil {
+        return logical.ErrorResponse("library set %q not found", name), logical.ErrInvalidRequest
+    }
+
+    ttl := set.TTL
+    if v, ok := d.GetOk("ttl"); ok {
+        if requested := time.Duration(v.(int)) * time.Second; requested > 0 && requested < ttl {
+            ttl = requested
+        }
+    }
+
+    users, err := b.getLibraryUsers(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    var user *LibraryUser
+    for _, username := range set.Users {
+        if u, ok := users[username]; ok && u.Available {
+            user = u
+            break
+        }
+    }
+
+    if user == nil {
+        return logical.ErrorResponse("no users of library set %q are available for check-out", name), logical.ErrInvalidRequest
+    }
+
+    checkOutID, err := uuid.GenerateUUID()
+    if err != nil {
+        return nil, err
+    }
+
+    user.Available = false
+    user.CheckOutID = checkOutID
+    user.BorrowerEntityID = req.EntityID
+    user.BorrowerClientToken = req.ClientToken
+    if err := b.putLibraryUser(ctx, req.Storage, name, user); err != nil {
+        return nil, err
+    }
+
+    resp := b.Secret(secretLibraryType).Response(map[string]interface{}{
+        "accessKeyId":     user.AccessKeyID,
+        "secretAccessKey": user.SecretAccessKey,
+    }, map[string]interface{}{
+        "set":          name,
+        "accessKeyId":  user.AccessKeyID,
+        "check_out_id": checkOutID,
+    })
+    resp.Secret.TTL = ttl
+    resp.Secret.MaxTTL = set.MaxTTL
+
+    return resp, nil
+}
+
+// pathLibraryCheckInUpdate checks in users of a set, by default the ones
+// checked out by the caller
+func (b *minioBackend) pathLibraryCheckInUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    lock := b.libraryLock(name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    set, err := b.getLibrarySet(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    if set == nil {
+        return logical.ErrorResponse("library set %q not found", name), logical.ErrInvalidRequest
+    }
+
+    users, err := b.getLibraryUsers(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    usernames := trimStrings(d.Get("users").([]string))
+    if len(usernames) == 0 {
+        for _, username := range set.Users {
+            if u, ok := users[username]; ok && !u.Available && u.borrowedBy(req) {
+                usernames = append(usernames, username)
+            }
+        }
+    }
+
+    var checkIns []*LibraryUser
+    for _, username := range usernames {
+        user, ok := users[username]
+        if !ok {
+            return logical.ErrorResponse("user %q is not in library set %q", username, name), logical.ErrInvalidRequest
+        }
+        if user.Available {
+            continue
+        }
+        if !set.DisableCheckInEnforcement && !user.borrowedBy(req) {
+            return logical.ErrorResponse("user %q was checked out by someone else", username), logical.ErrInvalidRequest
+        }
+        checkIns = append(checkIns, user)
+    }
+
+    checkedIn := []string{}
+    for _, user := range checkIns {
+        if err := b.checkInLibraryUser(ctx, req.Storage, name, set, user); err != nil {
+            return nil, err
+        }
+        checkedIn = append(checkedIn, user.AccessKeyID)
+    }
+
+    return &logical.Response{
+    Data: map[string]interface{}{
+        "check_ins": checkedIn,
+    },
+    }, nil
+}
+
+// pathLibraryStatusRead reports the availability of the users of a set
+func (b *minioBackend) pathLibraryStatusRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    set, err := b.getLibrarySet(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    if set == nil {
+        return nil, nil
+    }
+
+    users, err := b.getLibraryUsers(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    status := make(map[string]interface{}, len(users))
+    for username, user := range users {
+        userStatus := map[string]interface{}{
+            "available": user.Available,
+        }
+        if !user.Available && user.BorrowerEntityID != "" {
+            userStatus["borrower_entity_id"] = user.BorrowerEntityID
+        }
+        status[username] = userStatus
+    }
+
+    return &logical.Response{
+    Data: status,
+    }, nil
+}
+
+// borrowedBy reports whether the request comes from the borrower of the user
+func (u *LibraryUser) borrowedBy(req *logical.Request) bool {
+    if u.BorrowerEntityID != "" {
+        return u.BorrowerEntityID == req.EntityID
+    }
+    return u.BorrowerClientToken == req.ClientToken
+}
+
+// takeOverLibraryUser adds a user to a set, setting the secret key of an
+// existing Minio user or creating it. The caller holds the set lock.
+func (b *minioBackend) takeOverLibraryUser(ctx context.Context, s logical.Storage, setName string, set *LibrarySet, username string) error {
+    b.Logger().Info("Adding user to library set", "set", setName, "username", username)
+
+    client, err := b.getMadminClient(ctx, s, set.Connection)
+    if err != nil {
+        return err
+    }
+
+    c, err := b.GetConnection(ctx, s, set.Connection)
+    if err != nil {
+        return err
+    }
+
+    secretAccessKey, err := b.generateSecretAccessKey(ctx, c, set.PasswordPolicy)
+    if err != nil {
+        return err
+    }
+
+    user := &LibraryUser{
+        AccessKeyID:     username,
+        SecretAccessKey: secretAccessKey,
+        Connection:      set.Connection,
+        Available:       true,
+    }
+
+    var walID string
+    _, err = client.GetUserInfo(ctx, username)
+    switch {
+    case err == nil:
+        // Vault takes over the secret key of a user which already exists.
+        // The user is stored first so the new secret key is never lost, and
+        // set again should the request fail once Minio may have it.
+        walID, err = framework.PutWAL(ctx, s, walTypeTakeOverLibraryUser, &walLibraryUser{
+            SetName:    setName,
+            Username:   username,
+            Connection: set.Connection,
+        })
+        if err != nil {
+            return fmt.Errorf("failed to write WAL entry: %v", err)
+        }
+
+        if err := b.putLibraryUser(ctx, s, setName, user); err != nil {
+            return err
+        }
+
+        if err := b.setUserSecret(ctx, s, set.Connection, username, secretAccessKey); err != nil {
+            return err
+        }
+        b.deleteUserWAL(ctx, s, walID)
+
+        return nil
+    case isNoSuchUser(err):
+        entry := &walUser{
+            RoleName:    setName,
+            AccessKeyID: username,
+            PolicyName:  set.PolicyName,
+            PolicyNames: set.PolicyNames,
+            Groups:      set.Groups,
+            Connection:  set.Connection,
+        }
+        walID, err = b.putUserWAL(ctx, s, walTypeAddLibraryUser, entry)
+        if err != nil {
+            return err
+        }
+
+        if err := b.createMinioUser(ctx, client, entry, secretAccessKey, nil); err != nil {
+            return err
+        }
+
+        user.Created = true
+        user.PolicyName = set.PolicyName
+        user.PolicyNames = set.PolicyNames
+        user.Groups = set.Groups
+    default:
+        return fmt.Errorf("failed to get user info by madmin: %v", err)
+    }
+
+    if err := b.putLibraryUser(ctx, s, setName, user); err != nil {
+        return err
+    }
+
+    if walID != "" {
+        b.deleteUserWAL(ctx, s, walID)
+    }
+
+    return nil
+}
+
+// releaseLibraryUser drops a user from a set, removing it from Minio if the
+// plugin created it
+func (b *minioBackend) releaseLibraryUser(ctx context.Context, s logical.Storage, setName string, user *LibraryUser) error {
+    b.Logger().Info("Removing user from library set", "set", setName, "username", user.AccessKeyID)
+
+    if user.Created {
+        err := b.removeMinioUser(ctx, s, &walUser{
+            RoleName:    setName,
+            AccessKeyID: user.AccessKeyID,
+            PolicyName:  user.PolicyName,
+            PolicyNames: user.PolicyNames,
+            Groups:      user.Groups,
+            Connection:  user.Connection,
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    if err := s.Delete(ctx, libraryUserStoragePrefix+setName+"/"+user.AccessKeyID); err != nil {
+        return fmt.Errorf("failed to delete library user from persistent storage: %v", err)
+    }
+
+    return nil
+}
+
+// checkInLibraryUser rotates the secret key of a checked out user and makes
+// it available again. The caller holds the set lock.
+func (b *minioBackend) checkInLibraryUser(ctx context.Context, s logical.Storage, setName string, set *LibrarySet, user *LibraryUser) error {
+    b.Logger().Info("Checking in library user", "set", setName, "username", user.AccessKeyID)
+
+    c, err := b.GetConnection(ctx, s, set.Connection)
+    if err != nil {
+        return err
+    }
+
+    secretAccessKey, err := b.generateSecretAccessKey(ctx, c, set.PasswordPolicy)
+    if err != nil {
+        return err
+    }
+
+    // Should storing fail, the user stays checked out and a retry rotates
+    // it again
+    if err := b.setUserSecret(ctx, s, user.Connection, user.AccessKeyID, secretAccessKey); err != nil {
+        return err
+    }
+
+    user.SecretAccessKey = secretAccessKey
+    user.Available = true
+    user.CheckOutID = ""
+    user.BorrowerEntityID = ""
+    user.BorrowerClientToken = ""
+
+    return b.putLibraryUser(ctx, s, setName, user)
+}
+
+// rollbackTakeOverLibraryUser sets the stored secret key of a library user
+// again, as Minio may not have got it. A user the stored set does not list
+// never became part of it and is deleted instead.
+func (b *minioBackend) rollbackTakeOverLibraryUser(ctx context.Context, req *logical.Request, data interface{}) error {
+    var entry walLibraryUser
+    if err := decodeWAL(data, &entry); err != nil {
+        return err
+    }
+
+    lock := b.libraryLock(entry.SetName)
+    lock.Lock()
+    defer lock.Unlock()
+
+    user, err := b.getLibraryUser(ctx, req.Storage, entry.SetName, entry.Username)
+    if err != nil {
+        return err
+    }
+
+    // Never stored, so Minio was left untouched, or released since
+    if user == nil || user.Connection != entry.Connection {
+        return nil
+    }
+
+    set, err := b.getLibrarySet(ctx, req.Storage, entry.SetName)
+    if err != nil {
+        return err
+    }
+    if set == nil || set.Connection != entry.Connection || !stringInSlice(entry.Username, set.Users) {
+        b.Logger().Info("Deleting library user of a set never stored", "set", entry.SetName, "username", entry.Username)
+        if err := req.Storage.Delete(ctx, libraryUserStoragePrefix+entry.SetName+"/"+entry.Username); err != nil {
+            return fmt.Errorf("failed to delete library user from persistent storage: %v", err)
+        }
+        return nil
+    }
+
+    b.Logger().Info("Completing interrupted library user take over", "set", entry.SetName, "username", entry.Username)
+    err = b.setUserSecret(ctx, req.Storage, user.Connection, user.AccessKeyID, user.SecretAccessKey)
+    if err != nil && !isNoSuchUser(err) {
+        return err
+    }
+
+    return nil
+}
+
+// rollbackAddLibraryUser removes a Minio user whose creation for a library
+// set never made it to storage
+func (b *minioBackend) rollbackAddLibraryUser(ctx context.Context, req *logical.Request, data interface{}) error {
+    var entry walUser
+    if err := decodeWAL(data, &entry); err != nil {
+        return err
+    }
+
+    lock := b.libraryLock(entry.RoleName)
+    lock.Lock()
+    defer lock.Unlock()
+
+    user, err := b.getLibraryUser(ctx, req.Storage, entry.RoleName, entry.AccessKeyID)
+    if err != nil {
+        return err
+    }
+
+    // The request completed and only failed to delete its WAL entry
+    if user != nil {
+        return nil
+    }
+
+    b.Logger().Info("Rolling back partially created library user", "set", entry.RoleName, "accessKeyId", entry.AccessKeyID)
+    return b.removeMinioUser(ctx, req.Storage, &entry)
+}
+
+// walLibraryUser records a library user about to get the new secret key
+// stored with it
+type walLibraryUser struct {
+    SetName    string `json:"set_name"`
+    Username   string `json:"username"`
+    Connection string `json:"connection"`
+}
+
+// getLibrarySet returns the library set name, or nil if it does not exist
+func (b *minioBackend) getLibrarySet(ctx context.Context, s logical.Storage, name string) (*LibrarySet, error) {
+    entry, err := s.Get(ctx, libraryStoragePrefix+name)
+    if err != nil {
+        return nil, fmt.Errorf("unable to retrieve library set %v: %v", name, err)
+    }
+
+    if entry == nil {
+        return nil, nil
+    }
+
+    var set LibrarySet
+    if err := entry.DecodeJSON(&set); err != nil {
+        return nil, fmt.Errorf("unable to decode library set %v: %v", name, err)
+    }
+
+    return &set, nil
+}
+
+// getLibraryUsers returns the users of a set by access key
+func (b *minioBackend) getLibraryUsers(ctx context.Context, s logical.Storage, setName string) (map[string]*LibraryUser, error) {
+    usernames, err := s.List(ctx, libraryUserStoragePrefix+setName+"/")
+    if err != nil {
+        return nil, fmt.Errorf("failed to list users of library set %s: %v", setName, err)
+    }
+
+    users := make(map[string]*LibraryUser, len(usernames))
+    for _, username := range usernames {
+        user, err := b.getLibraryUser(ctx, s, setName, username)
+        if err != nil {
+            return nil, err
+        }
+        if user != nil {
+            users[username] = user
+        }
+    }
+
+    return users, nil
+}
+
+func (b *minioBackend) getLibraryUser(ctx context.Context, s logical.Storage, setName string, username string) (*LibraryUser, error) {
+    entry, err := s.Get(ctx, libraryUserStoragePrefix+setName+"/"+username)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get library user from persistent storage: %v", err)
+    }
+
+    if entry == nil {
+        return nil, nil
+    }
+
+    var user LibraryUser
+    if err := entry.DecodeJSON(&user); err != nil {
+        return nil, fmt.Errorf("failed to decode library user: %v", err)
+    }
+
+    return &user, nil
+}
+
+func (b *minioBackend) putLibraryUser(ctx context.Context, s logical.Storage, setName string, user *LibraryUser) error {
+    entry, err := logical.StorageEntryJSON(libraryUserStoragePrefix+setName+"/"+user.AccessKeyID, user)
+    if err != nil {
+        return fmt.Errorf("failed to create storage entry: %v", err)
+    }
+
+    if err := s.Put(ctx, entry); err != nil {
+        return fmt.Errorf("failed to persist library user in persistent storage: %v", err)
+    }
+
+    return nil
+}
+
+// librarySetOfUser returns the library set holding username on the
+// connection, or an empty string if there is none
+func (b *minioBackend) librarySetOfUser(ctx context.Context, s logical.Storage, connection string, username string) (string, error) {
+    names, err := s.List(ctx, libraryStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list library sets: %v", err)
+    }
+
+    for _, name := range names {
+        user, err := b.getLibraryUser(ctx, s, name, username)
+        if err != nil {
+            return "", err
+        }
+        if user != nil && user.Connection == connection {
+            return name, nil
+        }
+    }
+
+    return "", nil
+}
+
+// uniqueStrings returns values without duplicates, keeping their order
+func uniqueStrings(values []string) []string {
+    seen := make(map[string]bool, len(values))
+    result := []string{}
+    for _, v := range values {
+        if !seen[v] {
+            seen[v] = true
+            result = append(result, v)
+        }
+    }
+    return result
+}
+
+// stringInSlice reports whether value is one of values
+func stringInSlice(value string, values []string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,183 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/stretchr/testify/require"
+)
+
+const (
+    TEST_LIBRARY_SET           = "test-library-set"
+    TEST_LIBRARY_EXISTING_USER = "library-existing-user"
+    TEST_LIBRARY_CREATED_USER  = "library-created-user"
+    TEST_SECRET_LIBRARY_TYPE   = "minio_library"
+)
+
+func TestPluginLibrary(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+    server.addUser(TEST_LIBRARY_EXISTING_USER, "initialSecret")
+    issuedUser := testTidyIssueUser(t, reqStorage)
+
+    t.Run("Test Library Write Error With Invalid Settings", func(t *testing.T) {
+        for name, d := range map[string]map[string]interface{}{
+            "missing users": {
+                "policy_name": TEST_POLICY_NAME,
+            },
+            "invalid user name": {
+                "users": "no",
+            },
+            "ttl above max ttl": {
+                "users":   TEST_LIBRARY_CREATED_USER,
+                "ttl":     "2h",
+                "max_ttl": "1h",
+            },
+            "admin user": {
+                "users": TEST_APP_OSS_ACCESS_KEY_ID,
+            },
+            "issued user": {
+                "users": issuedUser,
+            },
+        } {
+            resp, err := testLibraryRequest(t, reqStorage, logical.CreateOperation, TEST_LIBRARY_SET, nil, d)
+            require.Error(t, err, name)
+            require.True(t, resp.IsError(), name)
+        }
+
+        require.False(t, server.hasUser(TEST_LIBRARY_CREATED_USER))
+    })
+
+    t.Run("Test Library Write Takes Over And Creates Users", func(t *testing.T) {
+        _, err := testLibraryRequest(t, reqStorage, logical.CreateOperation, TEST_LIBRARY_SET, nil, map[string]interface{}{
+            "users":       TEST_LIBRARY_EXISTING_USER + "," + TEST_LIBRARY_CREATED_USER,
+            "policy_name": TEST_POLICY_NAME,
+            "ttl":         "1h",
+            "max_ttl":     "2h",
+        })
+        require.NoError(t, err)
+
+        require.NotEqual(t, "initialSecret", server.secretKey(TEST_LIBRARY_EXISTING_USER))
+        require.True(t, server.hasUser(TEST_LIBRARY_CREATED_USER))
+        require.Equal(t, []string{TEST_POLICY_NAME}, server.policies[TEST_LIBRARY_CREATED_USER])
+        require.Empty(t, server.policies[TEST_LIBRARY_EXISTING_USER])
+
+        resp, err := testLibraryRequest(t, reqStorage, logical.ReadOperation, TEST_LIBRARY_SET, nil, nil)
+        require.NoError(t, err)
+        require.Equal(t, []string{TEST_LIBRARY_EXISTING_USER, TEST_LIBRARY_CREATED_USER}, resp.Data["users"])
+        require.Equal(t, int64(3600), resp.Data["ttl"])
+        require.Equal(t, int64(7200), resp.Data["max_ttl"])
+
+        resp, err = testLibraryRequest(t, reqStorage, logical.ListOperation, "", nil, nil)
+        require.NoError(t, err)
+        require.Equal(t, []string{TEST_LIBRARY_SET}, resp.Data["keys"])
+
+        resp, err = testLibraryRequest(t, reqStorage, logical.ReadOperation, TEST_LIBRARY_SET+"/status", nil, nil)
+        require.NoError(t, err)
+        require.Equal(t, map[string]interface{}{"available": true}, resp.Data[TEST_LIBRARY_EXISTING_USER])
+        require.Equal(t, map[string]interface{}{"available": true}, resp.Data[TEST_LIBRARY_CREATED_USER])
+    })
+
+    t.Run("Test Library Check Out And Check In", func(t *testing.T) {
+        borrower := &logical.Request{EntityID: "entity-1"}
+
+        first, err := testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-out", borrower, nil)
+        require.NoError(t, err)
+        require.Equal(t, TEST_LIBRARY_EXISTING_USER, first.Data["accessKeyId"])
+        require.Equal(t, server.secretKey(TEST_LIBRARY_EXISTING_USER), first.Data["secretAccessKey"])
+
+        second, err := testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-out", borrower, map[string]interface{}{
+            "ttl": "30m",
+        })
+        require.NoError(t, err)
+        require.Equal(t, TEST_LIBRARY_CREATED_USER, second.Data["accessKeyId"])
+        require.Equal(t, server.secretKey(TEST_LIBRARY_CREATED_USER), second.Data["secretAccessKey"])
+        require.Equal(t, float64(1800), second.Secret.TTL.Seconds())
+
+        resp, err := testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-out", borrower, nil)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        resp, err = testLibraryRequest(t, reqStorage, logical.ReadOperation, TEST_LIBRARY_SET+"/status", nil, nil)
+        require.NoError(t, err)
+        require.Equal(t, map[string]interface{}{"available": false, "borrower_entity_id": "entity-1"}, resp.Data[TEST_LIBRARY_EXISTING_USER])
+
+        // Only the borrower may check a user in
+        resp, err = testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-in", &logical.Request{EntityID: "entity-2"}, map[string]interface{}{
+            "users": TEST_LIBRARY_EXISTING_USER,
+        })
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        // A user checked out cannot leave the set
+        resp, err = testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET, nil, map[string]interface{}{
+            "users": TEST_LIBRARY_CREATED_USER,
+        })
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        resp, err = testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-in", borrower, map[string]interface{}{
+            "users": TEST_LIBRARY_EXISTING_USER,
+        })
+        require.NoError(t, err)
+        require.Equal(t, []string{TEST_LIBRARY_EXISTING_USER}, resp.Data["check_ins"])
+
+        // Checking in rotates the secret key
+        require.NotEqual(t, first.Data["secretAccessKey"], server.secretKey(TEST_LIBRARY_EXISTING_USER))
+
+        // The lease of a finished check-out no longer checks the user in
+        third, err := testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-out", borrower, nil)
+        require.NoError(t, err)
+        require.Equal(t, TEST_LIBRARY_EXISTING_USER, third.Data["accessKeyId"])
+
+        _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_LIBRARY_TYPE, first.Secret.InternalData)
+        require.NoError(t, err)
+        _, err = testSecretRenew(t, reqStorage, TEST_SECRET_LIBRARY_TYPE, first.Secret.InternalData)
+        require.Error(t, err)
+        require.Equal(t, third.Data["secretAccessKey"], server.secretKey(TEST_LIBRARY_EXISTING_USER))
+
+        // Revoking the current lease checks the user in
+        _, err = testSecretRenew(t, reqStorage, TEST_SECRET_LIBRARY_TYPE, second.Secret.InternalData)
+        require.NoError(t, err)
+        _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_LIBRARY_TYPE, second.Secret.InternalData)
+        require.NoError(t, err)
+        require.NotEqual(t, second.Data["secretAccessKey"], server.secretKey(TEST_LIBRARY_CREATED_USER))
+
+        // Without users, the caller's own check-outs are checked in
+        resp, err = testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-in", borrower, nil)
+        require.NoError(t, err)
+        require.Equal(t, []string{TEST_LIBRARY_EXISTING_USER}, resp.Data["check_ins"])
+    })
+
+    t.Run("Test Library Delete Removes Created Users Only", func(t *testing.T) {
+        _, err := testLibraryRequest(t, reqStorage, logical.DeleteOperation, TEST_LIBRARY_SET, nil, nil)
+        require.NoError(t, err)
+
+        require.False(t, server.hasUser(TEST_LIBRARY_CREATED_USER))
+        require.True(t, server.hasUser(TEST_LIBRARY_EXISTING_USER))
+
+        resp, err := testLibraryRequest(t, reqStorage, logical.ReadOperation, TEST_LIBRARY_SET, nil, nil)
+        require.NoError(t, err)
+        require.Nil(t, resp)
+    })
+}
+
+// testLibraryRequest sends a request to library/<path>, with the entity and
+// token of from when given
+func testLibraryRequest(t *testing.T, s logical.Storage, op logical.Operation, path string, from *logical.Request, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    req := &logical.Request{
+        Operation: op,
+        Path:      "library/" + path,
+        Data:      d,
+        Storage:   s,
+    }
+    if from != nil {
+        req.EntityID = from.EntityID
+        req.ClientToken = from.ClientToken
+    }
+    return b.HandleRequest(context.Background(), req)
+}
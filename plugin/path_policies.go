@@ -0,0 +1,297 @@
+package minio
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+)
+
+// List the canned policies of a Minio connection
+func (b *minioBackend) pathPolicies() *framework.Path {
+    return &framework.Path{
+    Pattern: "policies/?$",
+    HelpSynopsis: "List Minio canned policies.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "connection": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Name of the Minio connection. Defaults to config/root.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ListOperation: &framework.PathOperation{
+            Callback: b.pathPoliciesList,
+        },
+    },
+    }
+}
+
+// Define the CRUD functions for canned policies
+func (b *minioBackend) pathPoliciesCRUD() *framework.Path {
+    return &framework.Path{
+    Pattern: "policies/" + framework.GenericNameRegex("name"),
+    HelpSynopsis: "Manage a Minio canned policy.",
+    HelpDescription: "Use this endpoint to create, read, update and delete Minio canned policies which roles can attach with their policy_name field.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Policy name.",
+        },
+        "policy": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Minio policy in json format.",
+        },
+        "connection": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Name of the Minio connection. Defaults to config/root.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathPolicyRead,
+        },
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathPolicyWrite,
+        },
+        logical.DeleteOperation: &framework.PathOperation{
+            Callback: b.pathPolicyDelete,
+        },
+    },
+    }
+}
+
+// pathPoliciesList lists the canned policies of the connection
+func (b *minioBackend) pathPoliciesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    client, err := b.getMadminClient(ctx, req.Storage, d.Get("connection").(string))
+    if err != nil {
+        return nil, err
+    }
+
+    policies, err := client.ListCannedPolicies(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list policies by madmin: %v", err)
+    }
+
+    names := make([]string, 0, len(policies))
+    for name := range policies {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    return logical.ListResponse(names), nil
+}
+
+// pathPolicyRead returns a canned policy document
+func (b *minioBackend) pathPolicyRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    client, err := b.getMadminClient(ctx, req.Storage, d.Get("connection").(string))
+    if err != nil {
+        return nil, err
+    }
+
+    policy, err := client.InfoCannedPolicy(ctx, name)
+    if err != nil {
+        if isNoSuchPolicy(err) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to read policy by madmin: %v", err)
+    }
+
+    return &logical.Response{
+    Data: map[string]interface{}{
+        "policy": string(policy),
+    },
+    }, nil
+}
+
+// pathPolicyWrite creates or replaces a canned policy
+func (b *minioBackend) pathPolicyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+    connection := d.Get("connection").(string)
+
+    if err := validatePolicyDocument(d.Get("policy").(string)); err != nil {
+        return logical.ErrorResponse("invalid policy: %v", err), logical.ErrInvalidRequest
+    }
+
+    policy, err := compactPolicyDocument(d.Get("policy").(string))
+    if err != nil {
+        return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+    }
+
+    // The policies created for a single user are managed with the user
+    owner, err := b.inlinePolicyOwner(ctx, req.Storage, connection, name)
+    if err != nil {
+        return nil, err
+    }
+    if owner != "" {
+        return logical.ErrorResponse("policy %q is managed by this mount for %s", name, owner), logical.ErrInvalidRequest
+    }
+
+    client, err := b.getMadminClient(ctx, req.Storage, connection)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := client.AddCannedPolicy(ctx, name, policy); err != nil {
+        return nil, fmt.Errorf("failed to write policy by madmin: %v", err)
+    }
+
+    return nil, nil
+}
+
+// pathPolicyDelete deletes a canned policy unless something still uses it
+func (b *minioBackend) pathPolicyDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+    connection := d.Get("connection").(string)
+
+    owner, err := b.policyOwner(ctx, req.Storage, connection, name)
+    if err != nil {
+        return nil, err
+    }
+    if owner != "" {
+        return logical.ErrorResponse("policy %q is in use by %s", name, owner), logical.ErrInvalidRequest
+    }
+
+    client, err := b.getMadminClient(ctx, req.Storage, connection)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := client.RemoveCannedPolicy(ctx, name); err != nil && !isNoSuchPolicy(err) {
+        return nil, fmt.Errorf("failed to delete policy by madmin: %v", err)
+    }
+
+    return nil, nil
+}
+
+// policyOwner describes what attaches the policy name on the connection:
+// a role, a library set, or a user issued or taken over with it since. It
+// returns an empty string if nothing does.
+func (b *minioBackend) policyOwner(ctx context.Context, s logical.Storage, connection string, name string) (string, error) {
+    roles, err := b.ListRoles(ctx, s)
+    if err != nil {
+        return "", err
+    }
+
+    for _, roleName := range roles {
+        r, err := b.GetRole(ctx, s, roleName)
+        if err != nil {
+            return "", err
+        }
+
+        if r.Connection == connection && stringInSlice(name, append([]string{r.PolicyName}, r.PolicyNames...)) {
+            return fmt.Sprintf("role %q", roleName), nil
+        }
+    }
+
+    if err := b.migrateLegacyUserCreds(ctx, s); err != nil {
+        return "", err
+    }
+
+    userRoles, err := s.List(ctx, userStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list users from persistent storage: %v", err)
+    }
+
+    for _, roleName := range userRoles {
+        roleName = strings.TrimSuffix(roleName, "/")
+
+        users, err := b.getRoleUserCreds(ctx, s, roleName)
+        if err != nil {
+            return "", err
+        }
+        for _, userCreds := range users {
+            policies := append([]string{userCreds.PolicyName, userCreds.InlinePolicy}, userCreds.PolicyNames...)
+            if userCreds.Connection == connection && stringInSlice(name, policies) {
+                return fmt.Sprintf("user %q of role %q", userCreds.AccessKeyID, roleName), nil
+            }
+        }
+    }
+
+    sets, err := s.List(ctx, libraryStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list library sets: %v", err)
+    }
+
+    for _, setName := range sets {
+        set, err := b.getLibrarySet(ctx, s, setName)
+        if err != nil {
+            return "", err
+        }
+        if set != nil && set.Connection == connection && stringInSlice(name, append([]string{set.PolicyName}, set.PolicyNames...)) {
+            return fmt.Sprintf("library set %q", setName), nil
+        }
+
+        users, err := b.getLibraryUsers(ctx, s, setName)
+        if err != nil {
+            return "", err
+        }
+        for _, user := range users {
+            if user.Connection == connection && stringInSlice(name, append([]string{user.PolicyName}, user.PolicyNames...)) {
+                return fmt.Sprintf("user %q of library set %q", user.AccessKeyID, setName), nil
+            }
+        }
+    }
+
+    return "", nil
+}
+
+// inlinePolicyOwner describes the issued user the policy name was created
+// for on the connection, or returns an empty string if there is none
+func (b *minioBackend) inlinePolicyOwner(ctx context.Context, s logical.Storage, connection string, name string) (string, error) {
+    if err := b.migrateLegacyUserCreds(ctx, s); err != nil {
+        return "", err
+    }
+
+    userRoles, err := s.List(ctx, userStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list users from persistent storage: %v", err)
+    }
+
+    for _, roleName := range userRoles {
+        roleName = strings.TrimSuffix(roleName, "/")
+
+        users, err := b.getRoleUserCreds(ctx, s, roleName)
+        if err != nil {
+            return "", err
+        }
+        for _, userCreds := range users {
+            if userCreds.Connection == connection && userCreds.InlinePolicy == name {
+                return fmt.Sprintf("user %q of role %q", userCreds.AccessKeyID, roleName), nil
+            }
+        }
+    }
+
+    return "", nil
+}
+
+// compactPolicyDocument checks a policy is a JSON object and compacts it
+func compactPolicyDocument(policy string) ([]byte, error) {
+    var document map[string]interface{}
+    if err := json.Unmarshal([]byte(policy), &document); err != nil {
+        return nil, fmt.Errorf("policy must be a JSON object: %v", err)
+    }
+
+    var buf bytes.Buffer
+    if err := json.Compact(&buf, []byte(policy)); err != nil {
+        return nil, fmt.Errorf("policy must be a JSON object: %v", err)
+    }
+
+    return buf.Bytes(), nil
+}
+
+// isNoSuchPolicy reports whether err is Minio telling us the policy does not exist
+func isNoSuchPolicy(err error) bool {
+    return madmin.ToErrorResponse(err).Code == "XMinioAdminNoSuchPolicy"
+}
@@ -0,0 +1,156 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPluginPolicies(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    t.Run("Test Policy Apis With No Error", func(t *testing.T) {
+        _, err := testPolicyRequest(t, reqStorage, logical.UpdateOperation, TEST_POLICY_NAME, map[string]interface{}{
+            "policy": TEST_POLICY_DOCUMENT,
+        })
+        require.NoError(t, err)
+
+        resp, err := testPolicyRequest(t, reqStorage, logical.ReadOperation, TEST_POLICY_NAME, nil)
+        require.NoError(t, err)
+        require.JSONEq(t, TEST_POLICY_DOCUMENT, resp.Data["policy"].(string))
+
+        _, err = testPolicyRequest(t, reqStorage, logical.UpdateOperation, "other-policy", map[string]interface{}{
+            "policy": TEST_POLICY_DOCUMENT,
+        })
+        require.NoError(t, err)
+
+        resp, err = testPolicyRequest(t, reqStorage, logical.ListOperation, "", nil)
+        require.NoError(t, err)
+        require.Equal(t, []string{"other-policy", TEST_POLICY_NAME}, resp.Data["keys"])
+
+        _, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, "other-policy", nil)
+        require.NoError(t, err)
+
+        resp, err = testPolicyRequest(t, reqStorage, logical.ReadOperation, "other-policy", nil)
+        require.NoError(t, err)
+        require.Nil(t, resp)
+
+        // Deleting a missing policy is not an error
+        _, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, "other-policy", nil)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Policy Write Error With Invalid Document", func(t *testing.T) {
+        for _, policy := range []string{"", "not json", "[]", `{"Statement": []}`, `{"Statement": [{"Effect": "Allow"}]}`} {
+            resp, err := testPolicyRequest(t, reqStorage, logical.UpdateOperation, "invalid-policy", map[string]interface{}{
+                "policy": policy,
+            })
+            require.Error(t, err)
+            require.True(t, resp.IsError())
+        }
+    })
+
+    t.Run("Test Policy Write Error When Managed For A User", func(t *testing.T) {
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "policy_name":      TEST_POLICY_NAME,
+            "policy_document":  TEST_POLICY_DOCUMENT,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        accessKeyId := resp.Data["accessKeyId"].(string)
+
+        resp, err = testPolicyRequest(t, reqStorage, logical.UpdateOperation, accessKeyId, map[string]interface{}{
+            "policy": TEST_POLICY_DOCUMENT,
+        })
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        _, err = testPolicyRequest(t, reqStorage, logical.UpdateOperation, accessKeyId, map[string]interface{}{
+            "policy": TEST_POLICY_DOCUMENT,
+        })
+        require.NoError(t, err)
+        _, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, accessKeyId, nil)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Policy Delete Error When Used By Role", func(t *testing.T) {
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        _, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, TEST_POLICY_NAME, nil)
+        require.Error(t, err)
+
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+
+        _, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, TEST_POLICY_NAME, nil)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Policy Delete Error When Attached Otherwise", func(t *testing.T) {
+        // Attached as an additional policy of a role
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "policy_names":    "extra-policy",
+            "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+        resp, err := testPolicyRequest(t, reqStorage, logical.DeleteOperation, "extra-policy", nil)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        // Still attached to a user issued before the role changed
+        _, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        _, err = testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "policy_names": TEST_POLICY_NAME,
+        })
+        require.NoError(t, err)
+        resp, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, "extra-policy", nil)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        _, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, "extra-policy", nil)
+        require.NoError(t, err)
+
+        // Attached by a library set
+        _, err = testLibraryRequest(t, reqStorage, logical.CreateOperation, TEST_LIBRARY_SET, nil, map[string]interface{}{
+            "users":        TEST_LIBRARY_CREATED_USER,
+            "policy_names": "library-policy",
+        })
+        require.NoError(t, err)
+        resp, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, "library-policy", nil)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        _, err = testLibraryRequest(t, reqStorage, logical.DeleteOperation, TEST_LIBRARY_SET, nil, nil)
+        require.NoError(t, err)
+        _, err = testPolicyRequest(t, reqStorage, logical.DeleteOperation, "library-policy", nil)
+        require.NoError(t, err)
+    })
+}
+
+func testPolicyRequest(t *testing.T, s logical.Storage, op logical.Operation, name string, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: op,
+        Path:      "policies/" + name,
+        Data:      d,
+        Storage:   s,
+    })
+}
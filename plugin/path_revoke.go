@@ -0,0 +1,165 @@
+package minio
+
+import (
+    "context"
+    "fmt"
+    "regexp"
+    "strings"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+)
+
+// Names roles and connections may have, as accepted by their own paths
+var (
+    revokeRoleNameRegex       = regexp.MustCompile("^" + framework.GenericNameRegex("role") + "$")
+    revokeConnectionNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+)
+
+// Define the revoke path
+func (b *minioBackend) pathRevoke() *framework.Path {
+    return &framework.Path{
+    Pattern: "revoke",
+    HelpSynopsis: "Revoke a Minio user issued by this mount.",
+    HelpDescription: "Use this endpoint to revoke a user by its access key, such as a leaked one, whichever lease it was issued with. Only users issued by the roles of this mount can be revoked, following the revocation_grace_period of config/tidy.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "access_key_id": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Access key of the user to revoke.",
+        },
+        "role": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Role the user was issued by. Looked up from the access key if empty.",
+        },
+        "connection": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Name of the Minio connection the user is on. Defaults to config/root.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathRevokeUpdate,
+        },
+    },
+    }
+}
+
+// pathRevokeUpdate revokes the issued user with the given access key
+func (b *minioBackend) pathRevokeUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    accessKeyId := strings.TrimSpace(d.Get("access_key_id").(string))
+    roleName := strings.TrimSpace(d.Get("role").(string))
+    connection := strings.TrimSpace(d.Get("connection").(string))
+
+    if accessKeyId == "" {
+        return logical.ErrorResponse("access_key_id is required"), logical.ErrInvalidRequest
+    }
+    if err := validateUserName(accessKeyId); err != nil {
+        return logical.ErrorResponse("invalid access_key_id: %v", err), logical.ErrInvalidRequest
+    }
+    if roleName != "" && !revokeRoleNameRegex.MatchString(roleName) {
+        return logical.ErrorResponse("invalid role name %q", roleName), logical.ErrInvalidRequest
+    }
+    if connection != "" && !revokeConnectionNameRegex.MatchString(connection) {
+        return logical.ErrorResponse("invalid connection name %q", connection), logical.ErrInvalidRequest
+    }
+
+    if roleName == "" {
+        var err error
+        roleName, err = b.roleOfIssuedUser(ctx, req.Storage, connection, accessKeyId)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    if roleName != "" {
+        revoked, err := b.revokeIssuedUser(ctx, req, roleName, connection, accessKeyId)
+        if err != nil {
+            return nil, err
+        }
+        if revoked {
+            return &logical.Response{
+            Data: map[string]interface{}{
+                "role": roleName,
+                "access_key_id": accessKeyId,
+            },
+            }, nil
+        }
+    }
+
+    // Users bound to the mount without having been issued by it are left
+    // alone
+    staticRole, err := b.staticRoleOfUser(ctx, req.Storage, connection, accessKeyId)
+    if err != nil {
+        return nil, err
+    }
+    if staticRole != "" {
+        return logical.ErrorResponse("user %q of static role %q was not created by this mount and cannot be revoked", accessKeyId, staticRole), logical.ErrInvalidRequest
+    }
+
+    librarySet, err := b.librarySetOfUser(ctx, req.Storage, connection, accessKeyId)
+    if err != nil {
+        return nil, err
+    }
+    if librarySet != "" {
+        return logical.ErrorResponse("user %q belongs to library set %q and is checked in rather than revoked", accessKeyId, librarySet), logical.ErrInvalidRequest
+    }
+
+    return logical.ErrorResponse("user %q is not managed by this mount", accessKeyId), logical.ErrNotFound
+}
+
+// revokeIssuedUser revokes the user accessKeyId issued by roleName on the
+// connection, reporting false if there is none
+func (b *minioBackend) revokeIssuedUser(ctx context.Context, req *logical.Request, roleName string, connection string, accessKeyId string) (bool, error) {
+    lock := b.roleLock(roleName)
+    lock.Lock()
+    defer lock.Unlock()
+
+    userCreds, err := b.findUserCreds(ctx, req.Storage, roleName, "", accessKeyId)
+    if err != nil {
+        return false, err
+    }
+    if userCreds == nil || userCreds.Connection != connection {
+        return false, nil
+    }
+
+    role, err := b.getIssuingRole(ctx, req.Storage, roleName, userCreds)
+    if err != nil {
+        return false, err
+    }
+
+    b.Logger().Info("Revoking user by access key", "role", roleName, "accessKeyId", accessKeyId)
+    if err := b.revokeUser(ctx, req, role, roleName, userCreds); err != nil {
+        return false, err
+    }
+
+    return true, nil
+}
+
+// roleOfIssuedUser returns the role which issued accessKeyId on the
+// connection, or an empty string if there is none
+func (b *minioBackend) roleOfIssuedUser(ctx context.Context, s logical.Storage, connection string, accessKeyId string) (string, error) {
+    if err := b.migrateLegacyUserCreds(ctx, s); err != nil {
+        return "", err
+    }
+
+    roleNames, err := s.List(ctx, userStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list users from persistent storage: %v", err)
+    }
+
+    for _, roleName := range roleNames {
+        roleName = strings.TrimSuffix(roleName, "/")
+
+        userCreds, err := b.findUserCreds(ctx, s, roleName, "", accessKeyId)
+        if err != nil {
+            return "", err
+        }
+        if userCreds != nil && userCreds.Connection == connection {
+            return roleName, nil
+        }
+    }
+
+    return "", nil
+}
@@ -0,0 +1,134 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPluginRevoke(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+    accessKeyId := testTidyIssueUser(t, reqStorage)
+
+    t.Run("Test Revoke Error When Access Key Is Not Managed", func(t *testing.T) {
+        for name, d := range map[string]map[string]interface{}{
+            "unknown access key": {
+                "access_key_id": "unknown-user",
+            },
+            "admin user": {
+                "access_key_id": TEST_APP_OSS_ACCESS_KEY_ID,
+            },
+            "other role": {
+                "access_key_id": accessKeyId,
+                "role":          "other-role",
+            },
+            "other connection": {
+                "access_key_id": accessKeyId,
+                "connection":    "other-connection",
+            },
+        } {
+            resp, err := testRevokeRequest(t, reqStorage, d)
+            require.Equal(t, logical.ErrNotFound, err, name)
+            require.True(t, resp.IsError(), name)
+        }
+
+        require.True(t, server.hasUser(accessKeyId))
+    })
+
+    t.Run("Test Revoke Error When Input Is Invalid", func(t *testing.T) {
+        for name, d := range map[string]map[string]interface{}{
+            "missing access key": {},
+            "access key with slash": {
+                "access_key_id": "../" + accessKeyId,
+            },
+            "short access key": {
+                "access_key_id": "ab",
+            },
+            "role with slash": {
+                "access_key_id": accessKeyId,
+                "role":          "../" + TEST_ROLE_NAME,
+            },
+            "connection with slash": {
+                "access_key_id": accessKeyId,
+                "connection":    "a/b",
+            },
+        } {
+            resp, err := testRevokeRequest(t, reqStorage, d)
+            require.Equal(t, logical.ErrInvalidRequest, err, name)
+            require.True(t, resp.IsError(), name)
+        }
+
+        require.True(t, server.hasUser(accessKeyId))
+    })
+
+    t.Run("Test Revoke Refuses Users Not Created By The Mount", func(t *testing.T) {
+        server.addUser(TEST_STATIC_USERNAME, "initialSecret")
+        _, err := testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "username":        TEST_STATIC_USERNAME,
+            "rotation_period": "1h",
+        })
+        require.NoError(t, err)
+
+        resp, err := testRevokeRequest(t, reqStorage, map[string]interface{}{
+            "access_key_id": TEST_STATIC_USERNAME,
+        })
+        require.Equal(t, logical.ErrInvalidRequest, err)
+        require.True(t, resp.IsError())
+        require.True(t, server.hasUser(TEST_STATIC_USERNAME))
+    })
+
+    t.Run("Test Revoke Removes The Exact User", func(t *testing.T) {
+        resp, err := testRevokeRequest(t, reqStorage, map[string]interface{}{
+            "access_key_id": accessKeyId,
+        })
+        require.NoError(t, err)
+        require.Equal(t, TEST_ROLE_NAME, resp.Data["role"])
+        require.False(t, server.hasUser(accessKeyId))
+
+        // The role issues a new user
+        resp, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        require.NotEqual(t, accessKeyId, resp.Data["accessKeyId"])
+
+        resp, err = testRevokeRequest(t, reqStorage, map[string]interface{}{
+            "access_key_id": accessKeyId,
+        })
+        require.Equal(t, logical.ErrNotFound, err)
+        require.True(t, resp.IsError())
+    })
+
+    t.Run("Test Revoke Follows The Revocation Grace Period", func(t *testing.T) {
+        _, err := testTidyRequest(t, reqStorage, logical.UpdateOperation, "config/tidy", map[string]interface{}{
+            "revocation_grace_period": "1h",
+        })
+        require.NoError(t, err)
+
+        resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        newAccessKeyId := resp.Data["accessKeyId"].(string)
+
+        _, err = testRevokeRequest(t, reqStorage, map[string]interface{}{
+            "access_key_id": newAccessKeyId,
+            "role":          TEST_ROLE_NAME,
+        })
+        require.NoError(t, err)
+        require.True(t, server.hasUser(newAccessKeyId))
+        require.Equal(t, madmin.AccountDisabled, server.userStatus(newAccessKeyId))
+    })
+}
+
+func testRevokeRequest(t *testing.T, s logical.Storage, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.UpdateOperation,
+        Path:      "revoke",
+        Data:      d,
+        Storage:   s,
+    })
+}
@@ -2,6 +2,7 @@ package minio
 
 import (
     "context"
+    "encoding/json"
     "errors"
     "fmt"
     "strings"
@@ -15,9 +16,16 @@ var (
     ErrRoleNotFound = errors.New("role not found")
 )
 
+const (
+    // Bounds Minio puts on the duration of STS credentials
+    minStsTTL = 900 * time.Second
+    maxStsTTL = 7 * 24 * time.Hour
+)
+
 const (
     StaticCredentialType = "static"
     StsCredentialType = "sts"
+    ServiceAccountCredentialType = "service_account"
 )
 
 // A role stored in the storage backend
@@ -26,9 +34,20 @@ type Role struct {
     // PolicyName is the policy to be attached when creating user static credential
     PolicyName string `json:"policy_name"`
 
-    // PolicyDocument is policy in json format used to create sts credential
+    // PolicyNames are additional policies attached to the users
+    PolicyNames []string `json:"policy_names"`
+
+    // Groups are the Minio groups the users are added to
+    Groups []string `json:"groups"`
+
+    // PolicyDocument is policy in json format used to create sts credential,
+    // to restrict service accounts, and as a per-user policy of static users
     PolicyDocument string `json:"policy_document"`
 
+    // ParentUser is the Minio user owning the service accounts of this
+    // role, the connection's admin user if empty
+    ParentUser string `json:"parent_user"`
+
     // Type of credential created
     CredentialType string `json:"credential_type"`
 
@@ -36,12 +55,32 @@ type Role struct {
     // followed by the Vault request ID
     UserNamePrefix string `json:"user_name_prefix"`
 
+    // PasswordPolicy is the Vault password policy generating user secret
+    // keys, overriding the connection's
+    PasswordPolicy string `json:"password_policy"`
+
+    // UserNameTemplate renders the user static access key, taking
+    // precedence over UserNamePrefix
+    UserNameTemplate string `json:"username_template"`
+
     // MaxStsTTL is the maximum TTL that STS token can exist before it expires for this role
     MaxStsTTL time.Duration `json:"max_sts_ttl"`
 
     // MaxTTL is the maximum TTL for static credential to exist after which new ones are created
     MaxTTL time.Duration `json:"max_ttl"`
-    
+
+    // Connection is the named Minio connection users are created on,
+    // empty for config/root
+    Connection string `json:"connection"`
+
+    // PerEntity issues a user of its own to every Vault entity instead of
+    // one shared by the whole role
+    PerEntity bool `json:"per_entity"`
+
+    // RotationOverlap is how long before a user expires a new one is
+    // issued, both staying valid until the old one expires
+    RotationOverlap time.Duration `json:"rotation_overlap"`
+
 }
 
 // List the defined roles
@@ -85,18 +124,54 @@ func (b *minioBackend) pathRolesCRUD() *framework.Path {
         Type: framework.TypeString,
         Description: "Prefix for static user access key generated by this role.",
         },
+        "username_template": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Template rendering the user static access key, overriding user_name_prefix. Provides .RoleName, .DisplayName, .EntityName and .RequestID.",
+        },
+        "password_policy": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Vault password policy generating user secret keys. Defaults to the connection's.",
+        },
         "policy_name": &framework.FieldSchema{
         Type: framework.TypeString,
         Description: "Minio policy name to attach static credentials.",
         },
+        "policy_names": &framework.FieldSchema{
+        Type: framework.TypeCommaStringSlice,
+        Description: "Additional Minio policy names to attach to users, alongside policy_name.",
+        },
+        "groups": &framework.FieldSchema{
+        Type: framework.TypeCommaStringSlice,
+        Description: "Minio groups users are added to.",
+        },
         "policy_document": &framework.FieldSchema{
         Type: framework.TypeString,
-        Description: "Minio policy in json format applied to STS credentials.",
+        Description: "Minio policy in json format applied to STS credentials and service accounts, or attached to each static user as its own policy.",
+        },
+        "parent_user": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Minio user owning the service accounts issued by this role. Defaults to the connection's admin user.",
         },
         "credential_type": &framework.FieldSchema{
         Type: framework.TypeString,
         Description: "Type of credential created.",
         },
+        "connection": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Name of the Minio connection to issue credentials on. Defaults to config/root.",
+        },
+        "per_entity": &framework.FieldSchema{
+        Type: framework.TypeBool,
+        Description: "Issue a separate Minio user to each Vault entity requesting credentials.",
+        },
+        "rotation_overlap": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Description: "How long before a user expires a new one is issued, the old one staying valid until it expires. Must be shorter than max_ttl.",
+        },
+        "verify_policies": &framework.FieldSchema{
+        Type: framework.TypeBool,
+        Description: "Check that policy_name and policy_names exist on the Minio server before saving.",
+        },
         "max_sts_ttl": &framework.FieldSchema{
         Type: framework.TypeDurationSecond,
         Default: "24h",
@@ -121,6 +196,9 @@ func (b *minioBackend) pathRolesCRUD() *framework.Path {
         logical.UpdateOperation: &framework.PathOperation{
             Callback: b.pathRoleWrite,
             },
+        logical.PatchOperation: &framework.PathOperation{
+            Callback: b.pathRoleWrite,
+            },
         logical.DeleteOperation: &framework.PathOperation{
             Callback: b.pathRoleDelete,
             },
@@ -155,16 +233,40 @@ func (b *minioBackend) pathRoleRead(ctx context.Context, req *logical.Request, d
     if r.CredentialType == StaticCredentialType {
         role_data = map[string]interface{}{
             "user_name_prefix": r.UserNamePrefix,
+            "username_template": r.UserNameTemplate,
+            "password_policy": r.PasswordPolicy,
             "policy_name": r.PolicyName,
+            "policy_names": r.PolicyNames,
+            "groups": r.Groups,
+            "per_entity": r.PerEntity,
+            "rotation_overlap": r.RotationOverlap.Seconds(),
+            "policy_document": r.PolicyDocument,
             "max_ttl": r.MaxTTL.Seconds(),
             "credential_type": r.CredentialType,
+            "connection": r.Connection,
         }
     } else if r.CredentialType == StsCredentialType {
         role_data = map[string]interface{}{
+            "username_template": r.UserNameTemplate,
+            "password_policy": r.PasswordPolicy,
             "policy_name": r.PolicyName,
+            "policy_names": r.PolicyNames,
+            "groups": r.Groups,
+            "per_entity": r.PerEntity,
+            "rotation_overlap": r.RotationOverlap.Seconds(),
             "policy_document": r.PolicyDocument,
             "max_sts_ttl": r.MaxStsTTL.Seconds(),
+            "max_ttl": r.MaxTTL.Seconds(),
+            "credential_type": r.CredentialType,
+            "connection": r.Connection,
+        }
+    } else if r.CredentialType == ServiceAccountCredentialType {
+        role_data = map[string]interface{}{
+            "parent_user": r.ParentUser,
+            "policy_document": r.PolicyDocument,
+            "max_ttl": r.MaxTTL.Seconds(),
             "credential_type": r.CredentialType,
+            "connection": r.Connection,
         }
     }
 
@@ -173,33 +275,123 @@ func (b *minioBackend) pathRoleRead(ctx context.Context, req *logical.Request, d
     }, nil
 }
 
-// pathRoleWrite creates/updates a role entry
+// pathRoleWrite creates a role entry or updates the supplied fields of an
+// existing one. Fields left out of a create take their defaults.
 func (b *minioBackend) pathRoleWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
     role := d.Get("role").(string)
 
-    var r Role
+    lock := b.roleLock(role)
+    lock.Lock()
+    defer lock.Unlock()
+
+    r := &Role{}
+    create := req.Operation == logical.CreateOperation
+    if !create {
+        existing, err := b.GetRole(ctx, req.Storage, role)
+        switch {
+        case err == ErrRoleNotFound && req.Operation == logical.PatchOperation:
+            return logical.ErrorResponse(err.Error()), logical.ErrNotFound
+        case err == ErrRoleNotFound:
+            create = true
+        case err != nil:
+            return nil, err
+        default:
+            r = existing
+        }
+    }
 
-    keys := []string{"user_name_prefix", "policy_name", "credential_type", "policy_document"}
+    // get returns the supplied value of key, or its default on create
+    get := func(key string) (interface{}, bool) {
+        if v, ok := d.GetOk(key); ok {
+            return v, true
+        }
+        if create {
+            return d.Get(key), true
+        }
+        return nil, false
+    }
+
+    keys := []string{"user_name_prefix", "username_template", "password_policy", "policy_name", "credential_type", "policy_document", "parent_user", "connection"}
 
     for _, key := range keys {
-        nv := strings.TrimSpace(d.Get(key).(string))
+        v, ok := get(key)
+        if !ok {
+            continue
+        }
+        nv := strings.TrimSpace(v.(string))
 
         switch key {
           case "user_name_prefix":
             r.UserNamePrefix = nv
+          case "password_policy":
+            r.PasswordPolicy = nv
+          case "username_template":
+            r.UserNameTemplate = nv
           case "policy_name":
             r.PolicyName = nv
           case "credential_type":
             r.CredentialType = nv
           case "policy_document":
             r.PolicyDocument = nv
+          case "parent_user":
+            r.ParentUser = nv
+          case "connection":
+            r.Connection = nv
         }
     }
 
-    r.MaxTTL = time.Duration(d.GetDefaultOrZero("max_ttl").(int)) * time.Second
-    r.MaxStsTTL = time.Duration(d.Get("max_sts_ttl").(int)) * time.Second
-    
-    entry, err := logical.StorageEntryJSON("roles/"+role, &r)
+    if v, ok := get("policy_names"); ok {
+        r.PolicyNames = trimStrings(v.([]string))
+    }
+    if v, ok := get("groups"); ok {
+        r.Groups = trimStrings(v.([]string))
+    }
+
+    if r.UserNameTemplate != "" {
+        if err := validateUserNameTemplate(r.UserNameTemplate); err != nil {
+            return logical.ErrorResponse("invalid username_template: %v", err), logical.ErrInvalidRequest
+        }
+    }
+
+    if err := b.validatePasswordPolicy(ctx, r.PasswordPolicy); err != nil {
+        return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+    }
+
+    if r.Connection != "" {
+        c, err := b.GetConnection(ctx, req.Storage, r.Connection)
+        if err != nil {
+            return nil, err
+        }
+        if !c.Configured {
+            return logical.ErrorResponse("connection %q does not exist", r.Connection), logical.ErrInvalidRequest
+        }
+    }
+
+    if v, ok := get("per_entity"); ok {
+        r.PerEntity = v.(bool)
+    }
+
+    if v, ok := get("max_ttl"); ok {
+        r.MaxTTL = time.Duration(v.(int)) * time.Second
+    }
+    if v, ok := get("max_sts_ttl"); ok {
+        r.MaxStsTTL = time.Duration(v.(int)) * time.Second
+    }
+    if v, ok := get("rotation_overlap"); ok {
+        r.RotationOverlap = time.Duration(v.(int)) * time.Second
+    }
+
+    if err := r.validate(); err != nil {
+        return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+    }
+
+    if d.Get("verify_policies").(bool) {
+        if err := b.verifyRolePolicies(ctx, req.Storage, r); err != nil {
+            return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+        }
+    }
+
+    entry, err := logical.StorageEntryJSON("roles/"+role, r)
     if err != nil {
         return nil, fmt.Errorf("failed to create storage entry: %v", err)
     }
@@ -214,6 +406,11 @@ func (b *minioBackend) pathRoleWrite(ctx context.Context, req *logical.Request,
 // pathRoleDelete deletes a role
 func (b *minioBackend) pathRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
     roleName := d.Get("role").(string)
+
+    lock := b.roleLock(roleName)
+    lock.Lock()
+    defer lock.Unlock()
+
     r, err := b.GetRole(ctx, req.Storage, roleName)
     if err != nil {
         return nil, err
@@ -256,4 +453,134 @@ func (b* minioBackend) GetRole(ctx context.Context, s logical.Storage, role stri
     }
 
     return &rv, nil
-}
\ No newline at end of file
+}
+
+// getIssuingRole returns the role userCreds were issued by. The role may
+// have been removed without its users, which are then removed with the
+// policy recorded for them.
+func (b *minioBackend) getIssuingRole(ctx context.Context, s logical.Storage, roleName string, userCreds *UserInfo) (*Role, error) {
+    role, err := b.GetRole(ctx, s, roleName)
+    if err == ErrRoleNotFound {
+        return &Role{PolicyName: userCreds.PolicyName}, nil
+    }
+
+    return role, err
+}
+
+// validate checks the role is usable for its credential type
+func (r *Role) validate() error {
+    switch r.CredentialType {
+    case StaticCredentialType:
+        if r.PolicyName == "" && len(r.PolicyNames) == 0 && r.PolicyDocument == "" {
+            return errors.New("static roles require policy_name, policy_names or policy_document")
+        }
+    case StsCredentialType:
+        if r.PolicyName == "" && len(r.PolicyNames) == 0 {
+            return errors.New("sts roles require policy_name or policy_names")
+        }
+        if r.MaxStsTTL < minStsTTL || r.MaxStsTTL > maxStsTTL {
+            return fmt.Errorf("max_sts_ttl must be between %s and %s", minStsTTL, maxStsTTL)
+        }
+    case ServiceAccountCredentialType:
+        if r.PerEntity {
+            return errors.New("per_entity is not supported by service_account roles, which issue a service account per request")
+        }
+        if r.RotationOverlap != 0 {
+            return errors.New("rotation_overlap is not supported by service_account roles, which issue a service account per request")
+        }
+    case "":
+        return errors.New("credential_type is required")
+    default:
+        return fmt.Errorf("unsupported credential_type %q, must be one of %s, %s or %s", r.CredentialType,
+            StaticCredentialType, StsCredentialType, ServiceAccountCredentialType)
+    }
+
+    if r.MaxTTL <= 0 {
+        return errors.New("max_ttl must be positive")
+    }
+
+    // The user issuing STS credentials must outlive them, or a new one
+    // would be needed for every request
+    if r.CredentialType == StsCredentialType && r.MaxTTL <= r.MaxStsTTL {
+        return errors.New("max_ttl must be longer than max_sts_ttl")
+    }
+
+    if r.RotationOverlap < 0 {
+        return errors.New("rotation_overlap must not be negative")
+    }
+    if r.RotationOverlap > 0 && r.RotationOverlap >= r.MaxTTL {
+        return errors.New("rotation_overlap must be shorter than max_ttl")
+    }
+
+    if r.PolicyDocument != "" {
+        if err := validatePolicyDocument(r.PolicyDocument); err != nil {
+            return fmt.Errorf("invalid policy_document: %v", err)
+        }
+    }
+
+    return nil
+}
+
+// validatePolicyDocument checks a policy is a JSON object with at least one
+// statement, each allowing or denying some actions
+func validatePolicyDocument(policy string) error {
+    var document struct {
+        Statement []map[string]interface{} `json:"Statement"`
+    }
+    if err := json.Unmarshal([]byte(policy), &document); err != nil {
+        return fmt.Errorf("policy must be a JSON object with a Statement list: %v", err)
+    }
+
+    if len(document.Statement) == 0 {
+        return errors.New("policy has no Statement")
+    }
+
+    for i, statement := range document.Statement {
+        if effect := statement["Effect"]; effect != "Allow" && effect != "Deny" {
+            return fmt.Errorf("statement %d: Effect must be Allow or Deny", i)
+        }
+        _, hasAction := statement["Action"]
+        _, hasNotAction := statement["NotAction"]
+        if !hasAction && !hasNotAction {
+            return fmt.Errorf("statement %d: Action is required", i)
+        }
+    }
+
+    return nil
+}
+
+// verifyRolePolicies checks the policies attached by name exist on the
+// role's connection
+func (b *minioBackend) verifyRolePolicies(ctx context.Context, s logical.Storage, r *Role) error {
+    policies := userPolicyNames(append([]string{r.PolicyName}, r.PolicyNames...)...)
+    if len(policies) == 0 {
+        return nil
+    }
+
+    client, err := b.getMadminClient(ctx, s, r.Connection)
+    if err != nil {
+        return err
+    }
+
+    for _, policyName := range policies {
+        if _, err := client.InfoCannedPolicy(ctx, policyName); err != nil {
+            if isNoSuchPolicy(err) {
+                return fmt.Errorf("policy %q does not exist", policyName)
+            }
+            return fmt.Errorf("failed to verify policy %q: %v", policyName, err)
+        }
+    }
+
+    return nil
+}
+
+// trimStrings trims every value and drops the empty ones
+func trimStrings(values []string) []string {
+    result := []string{}
+    for _, v := range values {
+        if v = strings.TrimSpace(v); v != "" {
+            result = append(result, v)
+        }
+    }
+    return result
+}
@@ -17,10 +17,12 @@ const (
     TEST_USERNAME_PREFIX        = "test-user-name-prefix"
     TEST_POLICY_NAME            = "test-policy-name"
     TEST_POLICY_DOCUMENT        = "{\n  \"Version\": \"2012-10-17\",\n  \"Statement\": [\n   {\n    \"Effect\": \"Allow\",\n    \"Action\": [\n     \"s3:GetBucketLocation\",\n     \"s3:GetObject\"\n    ],\n    \"Resource\": [\n     \"arn:aws:s3:::*\"\n    ]\n   }\n  ]\n }"
-    TEST_MAX_STS_TTL            = 100
+    TEST_MAX_STS_TTL            = 900
     TEST_MAX_TTL                = "720h"
     TEST_STATIC_CREDENTIAL_TYPE = "static"
     TEST_STS_CREDENTIAL_TYPE    = "sts"
+    TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE = "service_account"
+    TEST_PARENT_USER            = "test-parent-user"
 )
 
 func TestPluginRoleSuccess(t *testing.T) {
@@ -43,8 +45,8 @@ func TestPluginRoleSuccess(t *testing.T) {
         require.Equal(t, TEST_POLICY_NAME, resp.Data["policy_name"])
         require.Equal(t, TEST_STATIC_CREDENTIAL_TYPE, resp.Data["credential_type"])
 
-        days := resp.Data["max_ttl"].(float64) / 86400
-        duration := fmt.Sprintf("%.0fd", days)
+        hours := resp.Data["max_ttl"].(float64) / 3600
+        duration := fmt.Sprintf("%.0fh", hours)
         require.Equal(t, TEST_MAX_TTL, duration)
 
         // Updating Role details
@@ -66,8 +68,8 @@ func TestPluginRoleSuccess(t *testing.T) {
         require.Equal(t, TEST_POLICY_NAME, resp.Data["policy_name"])
         require.Equal(t, TEST_STATIC_CREDENTIAL_TYPE, resp.Data["credential_type"])
 
-        days = resp.Data["max_ttl"].(float64) / 86400
-        duration = fmt.Sprintf("%.0fd", days)
+        hours = resp.Data["max_ttl"].(float64) / 3600
+        duration = fmt.Sprintf("%.0fh", hours)
         require.Equal(t, TEST_MAX_TTL, duration)
 
         _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
@@ -101,8 +103,9 @@ func TestPluginRoleSuccess(t *testing.T) {
         // Updating Role details
         _, err = testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
             "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
             "policy_document":  TEST_POLICY_DOCUMENT,
-            "max_sts_ttl":		500,
+            "max_sts_ttl":		1800,
             "credential_type":  TEST_STS_CREDENTIAL_TYPE,
         })
         require.NoError(t, err)
@@ -115,7 +118,7 @@ func TestPluginRoleSuccess(t *testing.T) {
 
         require.Equal(t, TEST_POLICY_DOCUMENT, resp.Data["policy_document"])
         require.Equal(t, TEST_STS_CREDENTIAL_TYPE, resp.Data["credential_type"])
-        require.Equal(t, float64(500), resp.Data["max_sts_ttl"])
+        require.Equal(t, float64(1800), resp.Data["max_sts_ttl"])
 
         _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
         require.NoError(t, err)
@@ -126,6 +129,63 @@ func TestPluginRoleSuccess(t *testing.T) {
 
     })
 
+    t.Run("Test Role Apis Honor Max TTL", func(t *testing.T) {
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "max_ttl":          "48h",
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testRoleRead(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        require.Equal(t, float64(48*3600), resp.Data["max_ttl"])
+
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Role Apis With Policy Names And Groups", func(t *testing.T) {
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "policy_names":     "readonly, ,diagnostics",
+            "groups":           []string{"apps"},
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testRoleRead(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        require.Equal(t, []string{"readonly", "diagnostics"}, resp.Data["policy_names"])
+        require.Equal(t, []string{"apps"}, resp.Data["groups"])
+
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Role Apis for service account credential type With No Error", func(t *testing.T) {
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "parent_user":      TEST_PARENT_USER,
+            "policy_document":  TEST_POLICY_DOCUMENT,
+            "max_ttl":          TEST_MAX_TTL,
+            "credential_type":  TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testRoleRead(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        require.Equal(t, TEST_PARENT_USER, resp.Data["parent_user"])
+        require.Equal(t, TEST_POLICY_DOCUMENT, resp.Data["policy_document"])
+        require.Equal(t, TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE, resp.Data["credential_type"])
+        require.Equal(t, float64(720*3600), resp.Data["max_ttl"])
+
+        _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+    })
+
     t.Run("Test Role Existance Check Success", func(t *testing.T) {
         _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
             "role":             TEST_ROLE_NAME,
@@ -203,6 +263,127 @@ func TestPluginRoleWriteError(t *testing.T) {
         require.Nil(t, resp)
         require.Error(t, err)
     })
+
+    t.Run("Test Role Write Error With Invalid Role Settings", func(t *testing.T) {
+        for name, d := range map[string]map[string]interface{}{
+            "missing credential type": {
+                "policy_name": TEST_POLICY_NAME,
+            },
+            "unknown credential type": {
+                "policy_name":     TEST_POLICY_NAME,
+                "credential_type": "unknown",
+            },
+            "static role without policy": {
+                "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+            },
+            "sts role without policy": {
+                "policy_document": TEST_POLICY_DOCUMENT,
+                "credential_type": TEST_STS_CREDENTIAL_TYPE,
+            },
+            "malformed policy document": {
+                "policy_name":     TEST_POLICY_NAME,
+                "policy_document": "{not json",
+                "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+            },
+            "policy document without statement": {
+                "policy_name":     TEST_POLICY_NAME,
+                "policy_document": `{"Version": "2012-10-17", "Statement": []}`,
+                "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+            },
+            "policy document with invalid effect": {
+                "policy_name":     TEST_POLICY_NAME,
+                "policy_document": `{"Statement": [{"Effect": "Maybe", "Action": ["s3:*"]}]}`,
+                "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+            },
+            "sts ttl below minimum": {
+                "policy_name":     TEST_POLICY_NAME,
+                "max_sts_ttl":     899,
+                "credential_type": TEST_STS_CREDENTIAL_TYPE,
+            },
+            "sts ttl above maximum": {
+                "policy_name":     TEST_POLICY_NAME,
+                "max_sts_ttl":     "169h",
+                "credential_type": TEST_STS_CREDENTIAL_TYPE,
+            },
+            "sts max ttl below max sts ttl": {
+                "policy_name":     TEST_POLICY_NAME,
+                "max_ttl":         "1h",
+                "max_sts_ttl":     "2h",
+                "credential_type": TEST_STS_CREDENTIAL_TYPE,
+            },
+            "zero max ttl": {
+                "policy_name":     TEST_POLICY_NAME,
+                "max_ttl":         0,
+                "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+            },
+            "per entity service account": {
+                "per_entity":      true,
+                "credential_type": TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE,
+            },
+            "rotation overlap not below max ttl": {
+                "policy_name":      TEST_POLICY_NAME,
+                "max_ttl":          "1h",
+                "rotation_overlap": "1h",
+                "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+            },
+            "rotation overlap service account": {
+                "rotation_overlap": "1h",
+                "credential_type":  TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE,
+            },
+        } {
+            resp, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, d)
+            require.Error(t, err, name)
+            require.True(t, resp.IsError(), name)
+        }
+    })
+
+    t.Run("Test Role Write Verifies Policies Exist", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        s := new(logical.InmemStorage)
+        server.configure(t, s)
+
+        d := map[string]interface{}{
+            "policy_name":     TEST_POLICY_NAME,
+            "policy_names":    "readonly",
+            "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+            "verify_policies": true,
+        }
+        resp, err := testRoleCreateOrUpdate(t, s, TEST_ROLE_NAME, d)
+        require.Error(t, err)
+        require.Contains(t, resp.Error().Error(), TEST_POLICY_NAME)
+
+        for _, policyName := range []string{TEST_POLICY_NAME, "readonly"} {
+            _, err = testPolicyRequest(t, s, logical.UpdateOperation, policyName, map[string]interface{}{
+                "policy": TEST_POLICY_DOCUMENT,
+            })
+            require.NoError(t, err)
+        }
+
+        _, err = testRoleCreateOrUpdate(t, s, TEST_ROLE_NAME, d)
+        require.NoError(t, err)
+    })
+
+    t.Run("Test Role Write Error With Invalid Username Template", func(t *testing.T) {
+        resp, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":              TEST_ROLE_NAME,
+            "username_template": "{{ .RoleName",
+            "policy_name":       TEST_POLICY_NAME,
+            "credential_type":   TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.True(t, resp.IsError())
+        require.Error(t, err)
+    })
+
+    t.Run("Test Role Write Error With Username Template Not Unique", func(t *testing.T) {
+        resp, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":              TEST_ROLE_NAME,
+            "username_template": "{{ .RoleName }}-{{ .DisplayName }}",
+            "policy_name":       TEST_POLICY_NAME,
+            "credential_type":   TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.True(t, resp.IsError())
+        require.Error(t, err)
+    })
 }
 
 func TestPluginRoleDelete(t *testing.T) {
@@ -292,7 +473,7 @@ func TestPluginReadError(t *testing.T) {
         s := &logical.InmemStorage{}
         s.Underlying().FailGet(true)
         
-        resp, err := testRoleCreateOrUpdate(t, s, TEST_ROLE_NAME, map[string]interface{}{
+        resp, err := testRoleCreate(t, s, TEST_ROLE_NAME, map[string]interface{}{
             "role":             TEST_ROLE_NAME,
             "user_name_prefix": TEST_USERNAME_PREFIX,
             "policy_name":      TEST_POLICY_NAME,
@@ -308,6 +489,154 @@ func TestPluginReadError(t *testing.T) {
     })
 }
 
+func TestPluginRolePartialUpdate(t *testing.T) {
+    sys := logical.TestSystemView()
+    sys.SetPasswordPolicy("base-policy", func() (string, error) {
+        return "baseSecret", nil
+    })
+    sys.SetPasswordPolicy("new-policy", func() (string, error) {
+        return "newSecret", nil
+    })
+    b, err := getMinioBackendWithSystemView(t, sys)
+    require.NoError(t, err)
+
+    bases := map[string]map[string]interface{}{
+        TEST_STATIC_CREDENTIAL_TYPE: {
+            "user_name_prefix":  "base-prefix",
+            "username_template": "base-{{.RoleName}}-{{.RequestID}}",
+            "password_policy":   "base-policy",
+            "policy_name":       TEST_POLICY_NAME,
+            "policy_names":      "base-extra",
+            "groups":            "base-group",
+            "policy_document":   TEST_POLICY_DOCUMENT,
+            "max_ttl":           "48h",
+            "credential_type":   TEST_STATIC_CREDENTIAL_TYPE,
+        },
+        TEST_STS_CREDENTIAL_TYPE: {
+            "policy_name":     TEST_POLICY_NAME,
+            "policy_document": TEST_POLICY_DOCUMENT,
+            "max_sts_ttl":     TEST_MAX_STS_TTL,
+            "credential_type": TEST_STS_CREDENTIAL_TYPE,
+        },
+        TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE: {
+            "parent_user":     TEST_PARENT_USER,
+            "policy_document": TEST_POLICY_DOCUMENT,
+            "max_ttl":         "48h",
+            "credential_type": TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE,
+        },
+    }
+
+    newPolicyDocument := `{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":["s3:*"],"Resource":["arn:aws:s3:::*"]}]}`
+
+    cases := []struct {
+        base  string
+        field string
+        value interface{}
+        want  interface{}
+    }{
+        {TEST_STATIC_CREDENTIAL_TYPE, "user_name_prefix", "new-prefix", "new-prefix"},
+        {TEST_STATIC_CREDENTIAL_TYPE, "username_template", "new-{{.RoleName}}-{{.RequestID}}", "new-{{.RoleName}}-{{.RequestID}}"},
+        {TEST_STATIC_CREDENTIAL_TYPE, "password_policy", "new-policy", "new-policy"},
+        {TEST_STATIC_CREDENTIAL_TYPE, "policy_name", "new-policy-name", "new-policy-name"},
+        {TEST_STATIC_CREDENTIAL_TYPE, "policy_names", "new-extra,other-extra", []string{"new-extra", "other-extra"}},
+        {TEST_STATIC_CREDENTIAL_TYPE, "policy_names", "", []string{}},
+        {TEST_STATIC_CREDENTIAL_TYPE, "groups", "new-group", []string{"new-group"}},
+        {TEST_STATIC_CREDENTIAL_TYPE, "policy_document", newPolicyDocument, newPolicyDocument},
+        {TEST_STATIC_CREDENTIAL_TYPE, "max_ttl", "72h", float64(72 * 3600)},
+        {TEST_STATIC_CREDENTIAL_TYPE, "per_entity", true, true},
+        {TEST_STATIC_CREDENTIAL_TYPE, "rotation_overlap", "1h", float64(3600)},
+        {TEST_STS_CREDENTIAL_TYPE, "max_sts_ttl", 1800, float64(1800)},
+        {TEST_STS_CREDENTIAL_TYPE, "policy_document", newPolicyDocument, newPolicyDocument},
+        {TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE, "parent_user", "new-parent-user", "new-parent-user"},
+        {TEST_SERVICE_ACCOUNT_CREDENTIAL_TYPE, "max_ttl", "72h", float64(72 * 3600)},
+    }
+
+    for _, op := range []logical.Operation{logical.UpdateOperation, logical.PatchOperation} {
+        for _, tc := range cases {
+            t.Run(fmt.Sprintf("%s %s %s", op, tc.base, tc.field), func(t *testing.T) {
+                s := new(logical.InmemStorage)
+
+                _, err := testRoleRequest(t, b, s, logical.CreateOperation, TEST_ROLE_NAME, bases[tc.base])
+                require.NoError(t, err)
+
+                resp, err := testRoleRequest(t, b, s, logical.ReadOperation, TEST_ROLE_NAME, nil)
+                require.NoError(t, err)
+                want := resp.Data
+                want[tc.field] = tc.want
+
+                _, err = testRoleRequest(t, b, s, op, TEST_ROLE_NAME, map[string]interface{}{
+                    tc.field: tc.value,
+                })
+                require.NoError(t, err)
+
+                resp, err = testRoleRequest(t, b, s, logical.ReadOperation, TEST_ROLE_NAME, nil)
+                require.NoError(t, err)
+                require.Equal(t, want, resp.Data)
+            })
+        }
+    }
+
+    t.Run("Test Role Update Keeps Fields When Changing Credential Type", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+
+        _, err := testRoleRequest(t, b, s, logical.CreateOperation, TEST_ROLE_NAME, bases[TEST_STATIC_CREDENTIAL_TYPE])
+        require.NoError(t, err)
+
+        _, err = testRoleRequest(t, b, s, logical.UpdateOperation, TEST_ROLE_NAME, map[string]interface{}{
+            "credential_type": TEST_STS_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testRoleRequest(t, b, s, logical.ReadOperation, TEST_ROLE_NAME, nil)
+        require.NoError(t, err)
+        require.Equal(t, TEST_STS_CREDENTIAL_TYPE, resp.Data["credential_type"])
+        require.Equal(t, TEST_POLICY_NAME, resp.Data["policy_name"])
+        require.Equal(t, TEST_POLICY_DOCUMENT, resp.Data["policy_document"])
+        require.Equal(t, float64(24*3600), resp.Data["max_sts_ttl"])
+    })
+
+    t.Run("Test Role Update Rejects An Invalid Result", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+
+        _, err := testRoleRequest(t, b, s, logical.CreateOperation, TEST_ROLE_NAME, bases[TEST_STS_CREDENTIAL_TYPE])
+        require.NoError(t, err)
+
+        _, err = testRoleRequest(t, b, s, logical.PatchOperation, TEST_ROLE_NAME, map[string]interface{}{
+            "max_sts_ttl": 60,
+        })
+        require.ErrorIs(t, err, logical.ErrInvalidRequest)
+
+        resp, err := testRoleRequest(t, b, s, logical.ReadOperation, TEST_ROLE_NAME, nil)
+        require.NoError(t, err)
+        require.Equal(t, float64(TEST_MAX_STS_TTL), resp.Data["max_sts_ttl"])
+    })
+
+    t.Run("Test Role Create Uses Defaults", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+
+        _, err := testRoleRequest(t, b, s, logical.CreateOperation, TEST_ROLE_NAME, map[string]interface{}{
+            "policy_name":     TEST_POLICY_NAME,
+            "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testRoleRequest(t, b, s, logical.ReadOperation, TEST_ROLE_NAME, nil)
+        require.NoError(t, err)
+        require.Equal(t, float64(30*24*3600), resp.Data["max_ttl"])
+        require.Equal(t, "", resp.Data["policy_document"])
+    })
+
+    t.Run("Test Role Patch Unknown Role", func(t *testing.T) {
+        s := new(logical.InmemStorage)
+
+        resp, err := testRoleRequest(t, b, s, logical.PatchOperation, TEST_ROLE_NAME, map[string]interface{}{
+            "max_ttl": "72h",
+        })
+        require.ErrorIs(t, err, logical.ErrNotFound)
+        require.Error(t, resp.Error())
+    })
+}
+
 func TestPluginExistanceCheckError(t *testing.T) {
     reqStorage := new(logical.InmemStorage)
 
@@ -341,6 +670,22 @@ func testRoleCreateOrUpdate(t *testing.T, s logical.Storage, roleName string, d
     })
 }
 
+func testRoleCreate(t *testing.T, s logical.Storage, roleName string, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return testRoleRequest(t, b, s, logical.CreateOperation, roleName, d)
+}
+
+func testRoleRequest(t *testing.T, b logical.Backend, s logical.Storage, op logical.Operation, roleName string, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: op,
+        Path:      "roles/" + roleName,
+        Data:      d,
+        Storage:   s,
+    })
+}
+
 func testRoleRead(t *testing.T, s logical.Storage, roleName string) (*logical.Response, error) {
     t.Helper()
     b, _ := getMinioBackend(t)
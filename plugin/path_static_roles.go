@@ -0,0 +1,371 @@
+package minio
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+    staticRoleStoragePrefix = "static-roles/"
+
+    // Minimum rotation_period of static roles
+    minRotationPeriod = 5 * time.Second
+)
+
+// StaticRole binds an existing Minio user whose secret key Vault rotates
+type StaticRole struct {
+    // Username is the access key of the existing Minio user
+    Username string `json:"username"`
+
+    // RotationPeriod is how often the secret key is rotated
+    RotationPeriod time.Duration `json:"rotation_period"`
+
+    // PasswordPolicy is the Vault password policy generating secret keys,
+    // overriding the connection's
+    PasswordPolicy string `json:"password_policy"`
+
+    // Connection is the named Minio connection of the user, empty for
+    // config/root
+    Connection string `json:"connection"`
+
+    // SecretAccessKey is the current secret key of the user
+    SecretAccessKey string `json:"secret_access_key"`
+
+    // LastVaultRotation is when Vault last set the secret key
+    LastVaultRotation time.Time `json:"last_vault_rotation"`
+}
+
+// nextRotation returns when the secret key is due for rotation
+func (r *StaticRole) nextRotation() time.Time {
+    return r.LastVaultRotation.Add(r.RotationPeriod)
+}
+
+// List the defined static roles
+func (b *minioBackend) pathStaticRoles() *framework.Path {
+    return &framework.Path{
+    Pattern: "static-roles/?$",
+    HelpSynopsis: "List configured static roles.",
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ListOperation: &framework.PathOperation{
+            Callback: b.pathStaticRolesList,
+        },
+    },
+    }
+}
+
+// Define the CRUD functions for the static roles path
+func (b *minioBackend) pathStaticRolesCRUD() *framework.Path {
+    return &framework.Path{
+    Pattern: staticRoleStoragePrefix + framework.GenericNameRegex("name"),
+    HelpSynopsis: "Manage a static role over an existing Minio user.",
+    HelpDescription: "Use this endpoint to bind an existing Minio user to Vault, which rotates its secret key every rotation_period. Creating the role rotates the secret key right away.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Static role name.",
+        },
+        "username": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Access key of the existing Minio user. Cannot be changed once set.",
+        },
+        "rotation_period": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Description: "How often the secret key of the user is rotated.",
+        },
+        "password_policy": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Vault password policy generating secret keys. Defaults to the connection's.",
+        },
+        "connection": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Name of the Minio connection of the user. Defaults to config/root. Cannot be changed once set.",
+        },
+    },
+
+    ExistenceCheck: b.pathStaticRoleExistsCheck,
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.CreateOperation: &framework.PathOperation{
+            Callback: b.pathStaticRoleWrite,
+        },
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathStaticRoleRead,
+        },
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathStaticRoleWrite,
+        },
+        logical.DeleteOperation: &framework.PathOperation{
+            Callback: b.pathStaticRoleDelete,
+        },
+    },
+    }
+}
+
+// Define the static-creds path
+func (b *minioBackend) pathStaticCreds() *framework.Path {
+    return &framework.Path{
+    Pattern: "static-creds/" + framework.GenericNameRegex("name"),
+    HelpSynopsis: "Read the current credentials of a static role.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "name": &framework.FieldSchema{
+        Type: framework.TypeString,
+        Description: "Static role name.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathStaticCredsRead,
+        },
+    },
+    }
+}
+
+// pathStaticRolesList lists the currently defined static roles
+func (b *minioBackend) pathStaticRolesList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    names, err := req.Storage.List(ctx, staticRoleStoragePrefix)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list static roles: %v", err)
+    }
+
+    return logical.ListResponse(names), nil
+}
+
+// pathStaticRoleExistsCheck checks to see if a static role exists
+func (b *minioBackend) pathStaticRoleExistsCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+    role, err := b.getStaticRole(ctx, req.Storage, d.Get("name").(string))
+    if err != nil {
+        return false, err
+    }
+
+    return role != nil, nil
+}
+
+// pathStaticRoleRead returns a static role, without its secret key
+func (b *minioBackend) pathStaticRoleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    role, err := b.getStaticRole(ctx, req.Storage, d.Get("name").(string))
+    if err != nil {
+        return nil, err
+    }
+
+    if role == nil {
+        return nil, nil
+    }
+
+    return &logical.Response{
+    Data: map[string]interface{}{
+        "username": role.Username,
+        "rotation_period": int64(role.RotationPeriod.Seconds()),
+        "password_policy": role.PasswordPolicy,
+        "connection": role.Connection,
+        "last_vault_rotation": role.LastVaultRotation.Format(time.RFC3339),
+    },
+    }, nil
+}
+
+// pathStaticRoleWrite creates a static role, rotating the secret key of its
+// user, or updates the supplied fields of an existing one
+func (b *minioBackend) pathStaticRoleWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    lock := b.staticRoleLock(name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    role, err := b.getStaticRole(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    create := role == nil
+    if create {
+        role = &StaticRole{
+            Username:   strings.TrimSpace(d.Get("username").(string)),
+            Connection: strings.TrimSpace(d.Get("connection").(string)),
+        }
+        if role.Username == "" {
+            return logical.ErrorResponse("username is required"), logical.ErrInvalidRequest
+        }
+        if _, ok := d.GetOk("rotation_period"); !ok {
+            return logical.ErrorResponse("rotation_period is required"), logical.ErrInvalidRequest
+        }
+    } else {
+        if v, ok := d.GetOk("username"); ok && strings.TrimSpace(v.(string)) != role.Username {
+            return logical.ErrorResponse("username cannot be changed"), logical.ErrInvalidRequest
+        }
+        if v, ok := d.GetOk("connection"); ok && strings.TrimSpace(v.(string)) != role.Connection {
+            return logical.ErrorResponse("connection cannot be changed"), logical.ErrInvalidRequest
+        }
+    }
+
+    if v, ok := d.GetOk("rotation_period"); ok {
+        role.RotationPeriod = time.Duration(v.(int)) * time.Second
+    }
+    if v, ok := d.GetOk("password_policy"); ok {
+        role.PasswordPolicy = strings.TrimSpace(v.(string))
+    }
+
+    if role.RotationPeriod < minRotationPeriod {
+        return logical.ErrorResponse("rotation_period must be at least %s", minRotationPeriod), logical.ErrInvalidRequest
+    }
+
+    if err := b.validatePasswordPolicy(ctx, role.PasswordPolicy); err != nil {
+        return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+    }
+
+    if create {
+        c, err := b.GetConnection(ctx, req.Storage, role.Connection)
+        if err != nil {
+            return nil, err
+        }
+        if role.Connection != "" && !c.Configured {
+            return logical.ErrorResponse("connection %q does not exist", role.Connection), logical.ErrInvalidRequest
+        }
+
+        // Rotating the admin user would lock the plugin out
+        if role.Username == c.AccessKeyId {
+            return logical.ErrorResponse("user %q is the connection's admin user, rotate it with config/rotate-root", role.Username), logical.ErrInvalidRequest
+        }
+
+        if owner, err := b.staticRoleOfUser(ctx, req.Storage, role.Connection, role.Username); err != nil {
+            return nil, err
+        } else if owner != "" {
+            return logical.ErrorResponse("user %q is already managed by static role %q", role.Username, owner), logical.ErrInvalidRequest
+        }
+        if owner, err := b.librarySetOfUser(ctx, req.Storage, role.Connection, role.Username); err != nil {
+            return nil, err
+        } else if owner != "" {
+            return logical.ErrorResponse("user %q is already in library set %q", role.Username, owner), logical.ErrInvalidRequest
+        }
+        if owner, err := b.roleOfIssuedUser(ctx, req.Storage, role.Connection, role.Username); err != nil {
+            return nil, err
+        } else if owner != "" {
+            return logical.ErrorResponse("user %q was issued by role %q", role.Username, owner), logical.ErrInvalidRequest
+        }
+
+        // Vault only knows the secret key once it set it
+        if err := b.rotateStaticRole(ctx, req.Storage, name, role); err != nil {
+            if isNoSuchUser(err) {
+                return logical.ErrorResponse("Minio user %q does not exist", role.Username), logical.ErrInvalidRequest
+            }
+            return nil, err
+        }
+    } else if err := b.putStaticRole(ctx, req.Storage, name, role); err != nil {
+        return nil, err
+    }
+
+    b.pushStaticRole(name, role.nextRotation())
+
+    return nil, nil
+}
+
+// pathStaticRoleDelete deletes a static role, leaving its Minio user as is
+func (b *minioBackend) pathStaticRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    lock := b.staticRoleLock(name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    if err := req.Storage.Delete(ctx, staticRoleStoragePrefix+name); err != nil {
+        return nil, fmt.Errorf("failed to delete static role: %v", err)
+    }
+
+    b.credRotationQueue.PopByKey(name)
+
+    return nil, nil
+}
+
+// pathStaticCredsRead returns the current credentials of a static role
+func (b *minioBackend) pathStaticCredsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    name := d.Get("name").(string)
+
+    lock := b.staticRoleLock(name)
+    lock.RLock()
+    defer lock.RUnlock()
+
+    role, err := b.getStaticRole(ctx, req.Storage, name)
+    if err != nil {
+        return nil, err
+    }
+
+    if role == nil {
+        return logical.ErrorResponse("static role %q not found", name), logical.ErrInvalidRequest
+    }
+
+    ttl := time.Until(role.nextRotation())
+    if ttl < 0 {
+        ttl = 0
+    }
+
+    return &logical.Response{
+    Data: map[string]interface{}{
+        "accessKeyId": role.Username,
+        "secretAccessKey": role.SecretAccessKey,
+        "last_vault_rotation": role.LastVaultRotation.Format(time.RFC3339),
+        "rotation_period": int64(role.RotationPeriod.Seconds()),
+        "ttl": int64(ttl.Seconds()),
+    },
+    }, nil
+}
+
+// getStaticRole returns the static role name, or nil if it does not exist
+func (b *minioBackend) getStaticRole(ctx context.Context, s logical.Storage, name string) (*StaticRole, error) {
+    entry, err := s.Get(ctx, staticRoleStoragePrefix+name)
+    if err != nil {
+        return nil, fmt.Errorf("unable to retrieve static role %v: %v", name, err)
+    }
+
+    if entry == nil {
+        return nil, nil
+    }
+
+    var role StaticRole
+    if err := entry.DecodeJSON(&role); err != nil {
+        return nil, fmt.Errorf("unable to decode static role %v: %v", name, err)
+    }
+
+    return &role, nil
+}
+
+func (b *minioBackend) putStaticRole(ctx context.Context, s logical.Storage, name string, role *StaticRole) error {
+    entry, err := logical.StorageEntryJSON(staticRoleStoragePrefix+name, role)
+    if err != nil {
+        return fmt.Errorf("failed to create storage entry: %v", err)
+    }
+
+    if err := s.Put(ctx, entry); err != nil {
+        return fmt.Errorf("failed to write entry to storage: %v", err)
+    }
+
+    return nil
+}
+
+// staticRoleOfUser returns the static role bound to username on the
+// connection, or an empty string if there is none
+func (b *minioBackend) staticRoleOfUser(ctx context.Context, s logical.Storage, connection string, username string) (string, error) {
+    names, err := s.List(ctx, staticRoleStoragePrefix)
+    if err != nil {
+        return "", fmt.Errorf("failed to list static roles: %v", err)
+    }
+
+    for _, name := range names {
+        role, err := b.getStaticRole(ctx, s, name)
+        if err != nil {
+            return "", err
+        }
+        if role != nil && role.Connection == connection && role.Username == username {
+            return name, nil
+        }
+    }
+
+    return "", nil
+}
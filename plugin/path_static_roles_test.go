@@ -0,0 +1,171 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/stretchr/testify/require"
+)
+
+const (
+    TEST_STATIC_ROLE_NAME = "test-static-role"
+    TEST_STATIC_USERNAME  = "test-existing-user"
+)
+
+func TestPluginStaticRoles(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+    server.addUser(TEST_STATIC_USERNAME, "initialSecret")
+
+    t.Run("Test Static Role Write Error With Invalid Settings", func(t *testing.T) {
+        for name, d := range map[string]map[string]interface{}{
+            "missing username": {
+                "rotation_period": "1h",
+            },
+            "missing rotation period": {
+                "username": TEST_STATIC_USERNAME,
+            },
+            "rotation period below minimum": {
+                "username":        TEST_STATIC_USERNAME,
+                "rotation_period": 1,
+            },
+            "unknown user": {
+                "username":        "unknown-user",
+                "rotation_period": "1h",
+            },
+            "admin user": {
+                "username":        TEST_APP_OSS_ACCESS_KEY_ID,
+                "rotation_period": "1h",
+            },
+            "unknown connection": {
+                "username":        TEST_STATIC_USERNAME,
+                "rotation_period": "1h",
+                "connection":      "unknown",
+            },
+        } {
+            resp, err := testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, d)
+            require.Error(t, err, name)
+            require.True(t, resp.IsError(), name)
+        }
+
+        require.Equal(t, "initialSecret", server.secretKey(TEST_STATIC_USERNAME))
+    })
+
+    t.Run("Test Static Role Write Error With Users Managed Otherwise", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        server.addUser(TEST_LIBRARY_EXISTING_USER, "initialSecret")
+
+        _, err := testLibraryRequest(t, reqStorage, logical.CreateOperation, TEST_LIBRARY_SET, nil, map[string]interface{}{
+            "users": TEST_LIBRARY_EXISTING_USER,
+        })
+        require.NoError(t, err)
+        issuedUser := testTidyIssueUser(t, reqStorage)
+
+        for _, username := range []string{TEST_LIBRARY_EXISTING_USER, issuedUser} {
+            secretKey := server.secretKey(username)
+            resp, err := testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+                "username":        username,
+                "rotation_period": "1h",
+            })
+            require.Error(t, err, username)
+            require.True(t, resp.IsError(), username)
+            require.Equal(t, secretKey, server.secretKey(username), username)
+        }
+    })
+
+    t.Run("Test Static Role Create Rotates The Secret Key", func(t *testing.T) {
+        _, err := testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "username":        TEST_STATIC_USERNAME,
+            "rotation_period": "1h",
+        })
+        require.NoError(t, err)
+
+        secretKey := server.secretKey(TEST_STATIC_USERNAME)
+        require.NotEqual(t, "initialSecret", secretKey)
+
+        resp, err := testStaticRoleRequest(t, reqStorage, logical.ReadOperation, TEST_STATIC_ROLE_NAME, nil)
+        require.NoError(t, err)
+        require.Equal(t, TEST_STATIC_USERNAME, resp.Data["username"])
+        require.Equal(t, int64(3600), resp.Data["rotation_period"])
+        require.NotContains(t, resp.Data, "secret_access_key")
+
+        resp, err = testStaticCredsRead(t, reqStorage, TEST_STATIC_ROLE_NAME)
+        require.NoError(t, err)
+        require.Equal(t, TEST_STATIC_USERNAME, resp.Data["accessKeyId"])
+        require.Equal(t, secretKey, resp.Data["secretAccessKey"])
+        require.InDelta(t, 3600, resp.Data["ttl"], 5)
+    })
+
+    t.Run("Test Static Role User Is Bound Once", func(t *testing.T) {
+        resp, err := testStaticRoleRequest(t, reqStorage, logical.CreateOperation, "other-static-role", map[string]interface{}{
+            "username":        TEST_STATIC_USERNAME,
+            "rotation_period": "1h",
+        })
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+    })
+
+    t.Run("Test Static Role Update", func(t *testing.T) {
+        secretKey := server.secretKey(TEST_STATIC_USERNAME)
+
+        _, err := testStaticRoleRequest(t, reqStorage, logical.UpdateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "rotation_period": "2h",
+        })
+        require.NoError(t, err)
+
+        resp, err := testStaticRoleRequest(t, reqStorage, logical.ReadOperation, TEST_STATIC_ROLE_NAME, nil)
+        require.NoError(t, err)
+        require.Equal(t, TEST_STATIC_USERNAME, resp.Data["username"])
+        require.Equal(t, int64(7200), resp.Data["rotation_period"])
+
+        // Updates don't rotate
+        require.Equal(t, secretKey, server.secretKey(TEST_STATIC_USERNAME))
+
+        resp, err = testStaticRoleRequest(t, reqStorage, logical.UpdateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "username": "another-user",
+        })
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+    })
+
+    t.Run("Test Static Role List And Delete", func(t *testing.T) {
+        resp, err := testStaticRoleRequest(t, reqStorage, logical.ListOperation, "", nil)
+        require.NoError(t, err)
+        require.Equal(t, []string{TEST_STATIC_ROLE_NAME}, resp.Data["keys"])
+
+        _, err = testStaticRoleRequest(t, reqStorage, logical.DeleteOperation, TEST_STATIC_ROLE_NAME, nil)
+        require.NoError(t, err)
+
+        resp, err = testStaticCredsRead(t, reqStorage, TEST_STATIC_ROLE_NAME)
+        require.Error(t, err)
+        require.True(t, resp.IsError())
+
+        // The user was not created by Vault and outlives the role
+        require.True(t, server.hasUser(TEST_STATIC_USERNAME))
+    })
+}
+
+func testStaticRoleRequest(t *testing.T, s logical.Storage, op logical.Operation, name string, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: op,
+        Path:      "static-roles/" + name,
+        Data:      d,
+        Storage:   s,
+    })
+}
+
+func testStaticCredsRead(t *testing.T, s logical.Storage, name string) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.ReadOperation,
+        Path:      "static-creds/" + name,
+        Storage:   s,
+    })
+}
@@ -0,0 +1,331 @@
+package minio
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/helper/consts"
+    "github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+    tidyConfigStoragePath = "config/tidy"
+
+    defaultTidySafetyBuffer = time.Hour
+    defaultTidyInterval     = time.Hour
+)
+
+// TidyConfig controls the periodic removal of expired users
+type TidyConfig struct {
+    // Enabled turns the periodic tidy on, the tidy path always works
+    Enabled bool `json:"enabled"`
+
+    // Interval is the minimum time between two periodic tidy runs
+    Interval time.Duration `json:"interval"`
+
+    // SafetyBuffer is how long past its expiration a user is kept
+    SafetyBuffer time.Duration `json:"safety_buffer"`
+
+    // RevocationGracePeriod is how long a revoked user stays disabled before
+    // it is removed, revoked users being removed right away when zero
+    RevocationGracePeriod time.Duration `json:"revocation_grace_period"`
+}
+
+// Define the config/tidy path
+func (b *minioBackend) pathConfigTidy() *framework.Path {
+    return &framework.Path{
+    Pattern: "config/tidy",
+    HelpSynopsis: "Configure the periodic tidy of expired users.",
+    HelpDescription: "Use this endpoint to configure how often expired Minio users are removed, how long past their expiration they are kept, and how long revoked users stay disabled before removal.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "enabled": &framework.FieldSchema{
+        Type: framework.TypeBool,
+        Default: true,
+        Description: "Whether expired users are removed periodically.",
+        },
+        "interval": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Default: int(defaultTidyInterval.Seconds()),
+        Description: "Minimum time between two periodic tidy runs.",
+        },
+        "safety_buffer": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Default: int(defaultTidySafetyBuffer.Seconds()),
+        Description: "How long past its expiration a user is kept before being removed.",
+        },
+        "revocation_grace_period": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Description: "How long a revoked user is kept disabled on Minio before being removed. Revoked users are removed right away when 0.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.ReadOperation: &framework.PathOperation{
+            Callback: b.pathConfigTidyRead,
+        },
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathConfigTidyUpdate,
+        },
+    },
+    }
+}
+
+// Define the tidy path
+func (b *minioBackend) pathTidy() *framework.Path {
+    return &framework.Path{
+    Pattern: "tidy",
+    HelpSynopsis: "Remove expired Minio users.",
+    HelpDescription: "Use this endpoint to immediately remove expired users from Minio and Vault storage. The safety buffer defaults to the one of config/tidy.",
+
+    Fields: map[string]*framework.FieldSchema{
+        "safety_buffer": &framework.FieldSchema{
+        Type: framework.TypeDurationSecond,
+        Description: "How long past its expiration a user is kept before being removed.",
+        },
+    },
+
+    Operations: map[logical.Operation]framework.OperationHandler{
+        logical.UpdateOperation: &framework.PathOperation{
+            Callback: b.pathTidyUpdate,
+        },
+    },
+    }
+}
+
+func (b *minioBackend) pathConfigTidyRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    c, err := b.getTidyConfig(ctx, req.Storage)
+    if err != nil {
+        return nil, err
+    }
+
+    return &logical.Response{
+    Data: map[string]interface{}{
+        "enabled": c.Enabled,
+        "interval": int64(c.Interval.Seconds()),
+        "safety_buffer": int64(c.SafetyBuffer.Seconds()),
+        "revocation_grace_period": int64(c.RevocationGracePeriod.Seconds()),
+    },
+    }, nil
+}
+
+func (b *minioBackend) pathConfigTidyUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    c, err := b.getTidyConfig(ctx, req.Storage)
+    if err != nil {
+        return nil, err
+    }
+
+    if v, ok := d.GetOk("enabled"); ok {
+        c.Enabled = v.(bool)
+    }
+    if v, ok := d.GetOk("interval"); ok {
+        c.Interval = time.Duration(v.(int)) * time.Second
+    }
+    if v, ok := d.GetOk("safety_buffer"); ok {
+        c.SafetyBuffer = time.Duration(v.(int)) * time.Second
+    }
+    if v, ok := d.GetOk("revocation_grace_period"); ok {
+        c.RevocationGracePeriod = time.Duration(v.(int)) * time.Second
+    }
+
+    if c.Interval <= 0 {
+        return logical.ErrorResponse("interval must be positive"), logical.ErrInvalidRequest
+    }
+    if c.SafetyBuffer < 0 {
+        return logical.ErrorResponse("safety_buffer must not be negative"), logical.ErrInvalidRequest
+    }
+    if c.RevocationGracePeriod < 0 {
+        return logical.ErrorResponse("revocation_grace_period must not be negative"), logical.ErrInvalidRequest
+    }
+
+    entry, err := logical.StorageEntryJSON(tidyConfigStoragePath, c)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create storage entry: %v", err)
+    }
+
+    if err := req.Storage.Put(ctx, entry); err != nil {
+        return nil, fmt.Errorf("failed to write entry to storage: %v", err)
+    }
+
+    return nil, nil
+}
+
+// pathTidyUpdate runs the tidy on demand and reports the removed users
+func (b *minioBackend) pathTidyUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+    c, err := b.getTidyConfig(ctx, req.Storage)
+    if err != nil {
+        return nil, err
+    }
+
+    safetyBuffer := c.SafetyBuffer
+    if v, ok := d.GetOk("safety_buffer"); ok {
+        safetyBuffer = time.Duration(v.(int)) * time.Second
+    }
+    if safetyBuffer < 0 {
+        return logical.ErrorResponse("safety_buffer must not be negative"), logical.ErrInvalidRequest
+    }
+
+    if !b.tidyMutex.TryLock() {
+        return logical.ErrorResponse("a tidy operation is already in progress"), logical.ErrInvalidRequest
+    }
+    defer b.tidyMutex.Unlock()
+
+    now := time.Now()
+    cutoff := now.Add(-safetyBuffer)
+    removed, failures := b.tidyUsers(ctx, req, func(userCreds UserInfo) bool {
+        return userCreds.ExpirationDate.Before(cutoff) || isRevocationDue(userCreds, c.RevocationGracePeriod, now)
+    })
+
+    resp := &logical.Response{
+    Data: map[string]interface{}{
+        "removed": removed,
+        "removed_count": len(removed),
+    },
+    }
+    for _, failure := range failures {
+        resp.AddWarning(failure)
+    }
+
+    return resp, nil
+}
+
+// periodicFunc runs the tidy when enabled and its interval elapsed
+func (b *minioBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+    // Secondaries and standbys cannot write to storage
+    replicationState := b.System().ReplicationState()
+    if replicationState.HasState(consts.ReplicationDRSecondary | consts.ReplicationPerformanceStandby) {
+        return nil
+    }
+    if !b.System().LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary) {
+        return nil
+    }
+
+    c, err := b.getTidyConfig(ctx, req.Storage)
+    if err != nil {
+        return err
+    }
+
+    if !b.tidyMutex.TryLock() {
+        return nil
+    }
+    defer b.tidyMutex.Unlock()
+
+    // Revoked users are removed once their grace period elapsed, even with
+    // the tidy disabled
+    now := time.Now()
+    removed, failures := b.tidyUsers(ctx, req, func(userCreds UserInfo) bool {
+        return isRevocationDue(userCreds, c.RevocationGracePeriod, now)
+    })
+    if len(removed) > 0 || len(failures) > 0 {
+        b.Logger().Info("Revoked users removed", "removed", len(removed), "failed", len(failures))
+    }
+
+    if !c.Enabled {
+        return nil
+    }
+
+    if time.Since(b.lastTidy) < c.Interval {
+        return nil
+    }
+    b.lastTidy = now
+
+    cutoff := now.Add(-c.SafetyBuffer)
+    removed, failures = b.tidyUsers(ctx, req, func(userCreds UserInfo) bool {
+        return userCreds.ExpirationDate.Before(cutoff)
+    })
+    if len(removed) > 0 || len(failures) > 0 {
+        b.Logger().Info("Periodic tidy completed", "removed", len(removed), "failed", len(failures))
+    }
+
+    return nil
+}
+
+// tidyUsers removes every user due for removal. It returns the removed
+// users as <role>/<accessKeyId> and a message per user which could not be
+// removed.
+func (b *minioBackend) tidyUsers(ctx context.Context, req *logical.Request, due func(UserInfo) bool) ([]string, []string) {
+    removed := []string{}
+    var failures []string
+
+    if err := b.migrateLegacyUserCreds(ctx, req.Storage); err != nil {
+        return removed, []string{err.Error()}
+    }
+
+    roleNames, err := req.Storage.List(ctx, userStoragePrefix)
+    if err != nil {
+        return removed, []string{fmt.Sprintf("failed to list users from persistent storage: %v", err)}
+    }
+
+    for _, roleName := range roleNames {
+        roleName = strings.TrimSuffix(roleName, "/")
+
+        roleRemoved, roleFailures := b.tidyRoleUsers(ctx, req, roleName, due)
+        removed = append(removed, roleRemoved...)
+        failures = append(failures, roleFailures...)
+    }
+
+    return removed, failures
+}
+
+// tidyRoleUsers removes the users of roleName due for removal
+func (b *minioBackend) tidyRoleUsers(ctx context.Context, req *logical.Request, roleName string, due func(UserInfo) bool) ([]string, []string) {
+    var removed, failures []string
+
+    lock := b.roleLock(roleName)
+    lock.Lock()
+    defer lock.Unlock()
+
+    users, err := b.getRoleUserCreds(ctx, req.Storage, roleName)
+    if err != nil {
+        return nil, []string{err.Error()}
+    }
+
+    for _, userCreds := range users {
+        if !due(userCreds) {
+            continue
+        }
+
+        role, err := b.getIssuingRole(ctx, req.Storage, roleName, &userCreds)
+        if err != nil {
+            return removed, append(failures, err.Error())
+        }
+
+        b.Logger().Info("Tidying user", "role", roleName, "accessKeyId", userCreds.AccessKeyID)
+        if err := b.removeUser(ctx, req, role, roleName, &userCreds); err != nil {
+            b.Logger().Warn("Tidying user failed", "role", roleName, "accessKeyId", userCreds.AccessKeyID, "error", err)
+            failures = append(failures, fmt.Sprintf("failed to remove user %s of role %s: %v", userCreds.AccessKeyID, roleName, err))
+            continue
+        }
+
+        removed = append(removed, roleName+"/"+userCreds.AccessKeyID)
+    }
+
+    return removed, failures
+}
+
+// getTidyConfig returns the tidy configuration, or its defaults when unset
+func (b *minioBackend) getTidyConfig(ctx context.Context, s logical.Storage) (*TidyConfig, error) {
+    c := &TidyConfig{
+        Enabled:      true,
+        Interval:     defaultTidyInterval,
+        SafetyBuffer: defaultTidySafetyBuffer,
+    }
+
+    entry, err := s.Get(ctx, tidyConfigStoragePath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get tidy configuration: %v", err)
+    }
+
+    if entry == nil {
+        return c, nil
+    }
+
+    if err := entry.DecodeJSON(c); err != nil {
+        return nil, fmt.Errorf("failed to decode tidy configuration: %v", err)
+    }
+
+    return c, nil
+}
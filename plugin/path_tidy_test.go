@@ -0,0 +1,225 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPluginTidy(t *testing.T) {
+    t.Run("Test Tidy Config Defaults And Update", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+
+        resp, err := testTidyRequest(t, reqStorage, logical.ReadOperation, "config/tidy", nil)
+        require.NoError(t, err)
+        require.Equal(t, true, resp.Data["enabled"])
+        require.Equal(t, int64(3600), resp.Data["interval"])
+        require.Equal(t, int64(3600), resp.Data["safety_buffer"])
+        require.Equal(t, int64(0), resp.Data["revocation_grace_period"])
+
+        _, err = testTidyRequest(t, reqStorage, logical.UpdateOperation, "config/tidy", map[string]interface{}{
+            "enabled":       false,
+            "safety_buffer": "2h",
+        })
+        require.NoError(t, err)
+
+        resp, err = testTidyRequest(t, reqStorage, logical.ReadOperation, "config/tidy", nil)
+        require.NoError(t, err)
+        require.Equal(t, false, resp.Data["enabled"])
+        require.Equal(t, int64(3600), resp.Data["interval"])
+        require.Equal(t, int64(7200), resp.Data["safety_buffer"])
+
+        _, err = testTidyRequest(t, reqStorage, logical.UpdateOperation, "config/tidy", map[string]interface{}{
+            "interval": 0,
+        })
+        require.Error(t, err)
+    })
+
+    t.Run("Test Tidy Removes Expired Users Past The Safety Buffer", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        accessKeyId := testTidyIssueUser(t, reqStorage)
+
+        // Not expired yet
+        resp, err := testTidyRequest(t, reqStorage, logical.UpdateOperation, "tidy", map[string]interface{}{
+            "safety_buffer": 0,
+        })
+        require.NoError(t, err)
+        require.Equal(t, 0, resp.Data["removed_count"])
+
+        testExpireUser(t, reqStorage, accessKeyId, time.Now().Add(-30*time.Minute))
+
+        // Expired, but still within the default one hour buffer
+        resp, err = testTidyRequest(t, reqStorage, logical.UpdateOperation, "tidy", nil)
+        require.NoError(t, err)
+        require.Equal(t, 0, resp.Data["removed_count"])
+        require.True(t, server.hasUser(accessKeyId))
+
+        resp, err = testTidyRequest(t, reqStorage, logical.UpdateOperation, "tidy", map[string]interface{}{
+            "safety_buffer": "10m",
+        })
+        require.NoError(t, err)
+        require.Equal(t, 1, resp.Data["removed_count"])
+        require.Equal(t, []string{TEST_ROLE_NAME + "/" + accessKeyId}, resp.Data["removed"])
+        require.False(t, server.hasUser(accessKeyId))
+
+        keys, err := reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/")
+        require.NoError(t, err)
+        require.Empty(t, keys)
+    })
+
+    t.Run("Test Periodic Tidy", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        accessKeyId := testTidyIssueUser(t, reqStorage)
+        testExpireUser(t, reqStorage, accessKeyId, time.Now().Add(-2*time.Hour))
+
+        _, err := testTidyRequest(t, reqStorage, logical.UpdateOperation, "config/tidy", map[string]interface{}{
+            "enabled": false,
+        })
+        require.NoError(t, err)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.True(t, server.hasUser(accessKeyId))
+
+        _, err = testTidyRequest(t, reqStorage, logical.UpdateOperation, "config/tidy", map[string]interface{}{
+            "enabled": true,
+        })
+        require.NoError(t, err)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.False(t, server.hasUser(accessKeyId))
+    })
+}
+
+func TestPluginRevocationGracePeriod(t *testing.T) {
+    server := newTestMinioServer(t)
+    reqStorage := new(logical.InmemStorage)
+    server.configure(t, reqStorage)
+
+    _, err := testTidyRequest(t, reqStorage, logical.UpdateOperation, "config/tidy", map[string]interface{}{
+        "enabled":                 false,
+        "revocation_grace_period": "1h",
+    })
+    require.NoError(t, err)
+
+    accessKeyId := testTidyIssueUser(t, reqStorage)
+    resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    require.Equal(t, accessKeyId, resp.Data["accessKeyId"])
+
+    // The user is shared with the lease issued first
+    _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, resp.Secret.InternalData)
+    require.NoError(t, err)
+    require.Equal(t, madmin.AccountEnabled, server.userStatus(accessKeyId))
+
+    // Revoking disables the user but keeps it through the grace period
+    _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, resp.Secret.InternalData)
+    require.NoError(t, err)
+    require.True(t, server.hasUser(accessKeyId))
+    require.Equal(t, madmin.AccountDisabled, server.userStatus(accessKeyId))
+
+    _, err = testSecretRenew(t, reqStorage, TEST_SECRET_STATIC_TYPE, resp.Secret.InternalData)
+    require.Error(t, err)
+
+    // A revoked user is never issued again
+    resp, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    newAccessKeyId := resp.Data["accessKeyId"].(string)
+    require.NotEqual(t, accessKeyId, newAccessKeyId)
+
+    _, err = testRollback(t, reqStorage)
+    require.NoError(t, err)
+    require.True(t, server.hasUser(accessKeyId))
+
+    // The periodic job removes it once the grace period elapsed, even with
+    // the tidy disabled
+    testDisableUserSince(t, reqStorage, accessKeyId, time.Now().Add(-2*time.Hour))
+    _, err = testRollback(t, reqStorage)
+    require.NoError(t, err)
+    require.False(t, server.hasUser(accessKeyId))
+    require.True(t, server.hasUser(newAccessKeyId))
+
+    user, err := reqStorage.Get(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/"+accessKeyId)
+    require.NoError(t, err)
+    require.Nil(t, user)
+
+    // Deleting the role revokes its users through the grace period as well
+    _, err = testRoleDelete(t, reqStorage, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    require.True(t, server.hasUser(newAccessKeyId))
+    require.Equal(t, madmin.AccountDisabled, server.userStatus(newAccessKeyId))
+
+    testDisableUserSince(t, reqStorage, newAccessKeyId, time.Now().Add(-2*time.Hour))
+    _, err = testRollback(t, reqStorage)
+    require.NoError(t, err)
+    require.False(t, server.hasUser(newAccessKeyId))
+}
+
+// testTidyIssueUser creates a static role and issues its user
+func testTidyIssueUser(t *testing.T, s logical.Storage) string {
+    t.Helper()
+    _, err := testRoleCreateOrUpdate(t, s, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "policy_name":      TEST_POLICY_NAME,
+        "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    resp, err := testPathKeysCreateStaticCredentials(t, s, TEST_ROLE_NAME)
+    require.NoError(t, err)
+    return resp.Data["accessKeyId"].(string)
+}
+
+// testExpireUser rewrites the expiration date of a stored user
+func testExpireUser(t *testing.T, s logical.Storage, accessKeyId string, expiration time.Time) {
+    t.Helper()
+    key := userStoragePath + "/" + TEST_ROLE_NAME + "/" + accessKeyId
+    entry, err := s.Get(context.Background(), key)
+    require.NoError(t, err)
+    require.NotNil(t, entry)
+
+    var user map[string]interface{}
+    require.NoError(t, entry.DecodeJSON(&user))
+    user["expirationDate"] = expiration
+
+    entry, err = logical.StorageEntryJSON(key, user)
+    require.NoError(t, err)
+    require.NoError(t, s.Put(context.Background(), entry))
+}
+
+// testDisableUserSince rewrites when a stored user was disabled
+func testDisableUserSince(t *testing.T, s logical.Storage, accessKeyId string, disabledAt time.Time) {
+    t.Helper()
+    key := userStoragePath + "/" + TEST_ROLE_NAME + "/" + accessKeyId
+    entry, err := s.Get(context.Background(), key)
+    require.NoError(t, err)
+    require.NotNil(t, entry)
+
+    var user map[string]interface{}
+    require.NoError(t, entry.DecodeJSON(&user))
+    user["disabledAt"] = disabledAt
+
+    entry, err = logical.StorageEntryJSON(key, user)
+    require.NoError(t, err)
+    require.NoError(t, s.Put(context.Background(), entry))
+}
+
+func testTidyRequest(t *testing.T, s logical.Storage, op logical.Operation, path string, d map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: op,
+        Path:      path,
+        Data:      d,
+        Storage:   s,
+    })
+}
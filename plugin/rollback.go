@@ -0,0 +1,193 @@
+package minio
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+)
+
+const (
+    walTypeAddUser    = "addUser"
+    walTypeRemoveUser = "removeUser"
+    walTypeAddServiceAccount = "addServiceAccount"
+    walTypeRotateStaticRole = "rotateStaticRole"
+    walTypeAddLibraryUser = "addLibraryUser"
+    walTypeTakeOverLibraryUser = "takeOverLibraryUser"
+    walTypeRotateRoot = "rotateRoot"
+
+    // How old a WAL entry must be before the rollback manager acts on it
+    walRollbackMinAge = 5 * time.Minute
+)
+
+// walUser records a Minio user mutation before it is made
+type walUser struct {
+    RoleName     string   `json:"role_name"`
+    AccessKeyID  string   `json:"access_key_id"`
+    PolicyName   string   `json:"policy_name"`
+    PolicyNames  []string `json:"policy_names"`
+    // InlinePolicy is the canned policy created for this user alone
+    InlinePolicy string   `json:"inline_policy"`
+    Groups       []string `json:"groups"`
+    Connection   string   `json:"connection"`
+    // EntityID is the entity of a per_entity role's user
+    EntityID     string   `json:"entity_id,omitempty"`
+}
+
+// policies returns every policy attached to the user
+func (entry *walUser) policies() []string {
+    policyNames := append([]string{entry.PolicyName}, entry.PolicyNames...)
+    return userPolicyNames(append(policyNames, entry.InlinePolicy)...)
+}
+
+// walRollback is called by Vault's rollback manager for WAL entries left
+// behind by requests that did not complete
+func (b *minioBackend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+    // Static roles, library sets and the root rotation are not tied to
+    // issued users
+    switch kind {
+    case walTypeRotateStaticRole:
+        return b.rollbackRotateStaticRole(ctx, req, data)
+    case walTypeAddLibraryUser:
+        return b.rollbackAddLibraryUser(ctx, req, data)
+    case walTypeTakeOverLibraryUser:
+        return b.rollbackTakeOverLibraryUser(ctx, req, data)
+    case walTypeRotateRoot:
+        return b.rollbackRotateRoot(ctx, req)
+    }
+
+    var entry walUser
+    if err := decodeWAL(data, &entry); err != nil {
+        return err
+    }
+
+    lock := b.roleLock(entry.RoleName)
+    lock.Lock()
+    defer lock.Unlock()
+
+    switch kind {
+    case walTypeAddUser:
+        return b.rollbackAddUser(ctx, req, &entry)
+    case walTypeRemoveUser:
+        return b.rollbackRemoveUser(ctx, req, &entry)
+    case walTypeAddServiceAccount:
+        // No lease was returned for the service account
+        b.Logger().Info("Rolling back partially created minio service account", "role", entry.RoleName, "accessKeyId", entry.AccessKeyID)
+        return b.removeServiceAccount(ctx, req.Storage, entry.Connection, entry.AccessKeyID)
+    default:
+        return fmt.Errorf("unknown WAL entry type %q", kind)
+    }
+}
+
+// rollbackAddUser removes a Minio user whose creation never made it to storage
+func (b *minioBackend) rollbackAddUser(ctx context.Context, req *logical.Request, entry *walUser) error {
+    userCreds, err := b.findUserCreds(ctx, req.Storage, entry.RoleName, entry.EntityID, entry.AccessKeyID)
+    if err != nil {
+        return err
+    }
+
+    // The request completed and only failed to delete its WAL entry
+    if userCreds != nil {
+        return nil
+    }
+
+    b.Logger().Info("Rolling back partially created minio user", "role", entry.RoleName, "accessKeyId", entry.AccessKeyID)
+    return b.removeMinioUser(ctx, req.Storage, entry)
+}
+
+// rollbackRemoveUser finishes an interrupted removal. A half revoked
+// credential must not come back, so removals are rolled forward.
+func (b *minioBackend) rollbackRemoveUser(ctx context.Context, req *logical.Request, entry *walUser) error {
+    b.Logger().Info("Completing interrupted minio user removal", "role", entry.RoleName, "accessKeyId", entry.AccessKeyID)
+    if err := b.removeMinioUser(ctx, req.Storage, entry); err != nil {
+        return err
+    }
+
+    return b.deleteUserCreds(ctx, req.Storage, entry.RoleName, entry.EntityID, entry.AccessKeyID)
+}
+
+// removeMinioUser detaches the policies and removes the user along with its
+// inline policy, treating an already missing user as success
+func (b *minioBackend) removeMinioUser(ctx context.Context, s logical.Storage, entry *walUser) error {
+    client, err := b.getMadminClient(ctx, s, entry.Connection)
+    if err != nil {
+        return fmt.Errorf("failed to receive madmin client: %v", err)
+    }
+
+    // Removing the user drops its policy mappings and group memberships
+    // anyway, so failing to undo them only deserves a warning
+    for _, group := range entry.Groups {
+        err := client.UpdateGroupMembers(ctx, madmin.GroupAddRemove{
+            Group:    group,
+            Members:  []string{entry.AccessKeyID},
+            IsRemove: true,
+        })
+        if err != nil {
+            b.Logger().Warn("Removing user from group failed", "accessKeyId", entry.AccessKeyID, "group", group, "error", err)
+        }
+    }
+
+    if policies := entry.policies(); len(policies) > 0 {
+        _, err = client.DetachPolicy(ctx, madmin.PolicyAssociationReq{
+            Policies: policies,
+            User:     entry.AccessKeyID,
+        })
+        if err != nil && !isNoSuchUser(err) {
+            b.Logger().Warn("Detaching policy failed", "accessKeyId", entry.AccessKeyID, "error", err)
+        }
+    }
+
+    // A user already gone from Minio is what we want
+    if err := client.RemoveUser(ctx, entry.AccessKeyID); err != nil && !isNoSuchUser(err) {
+        return fmt.Errorf("failed to delete user access by madmin: %v", err)
+    }
+
+    if entry.InlinePolicy != "" {
+        if err := client.RemoveCannedPolicy(ctx, entry.InlinePolicy); err != nil && !isNoSuchPolicy(err) {
+            return fmt.Errorf("failed to delete user policy by madmin: %v", err)
+        }
+    }
+
+    return nil
+}
+
+// isNoSuchUser reports whether err is Minio telling us the user does not exist
+func isNoSuchUser(err error) bool {
+    return madmin.ToErrorResponse(err).Code == "XMinioAdminNoSuchUser"
+}
+
+// decodeWAL converts the generic WAL data back into its struct
+func decodeWAL(data interface{}, v interface{}) error {
+    raw, err := json.Marshal(data)
+    if err != nil {
+        return fmt.Errorf("failed to encode WAL entry: %v", err)
+    }
+
+    if err := json.Unmarshal(raw, v); err != nil {
+        return fmt.Errorf("failed to decode WAL entry: %v", err)
+    }
+
+    return nil
+}
+
+// putUserWAL records a pending user mutation
+func (b *minioBackend) putUserWAL(ctx context.Context, s logical.Storage, kind string, entry *walUser) (string, error) {
+    walID, err := framework.PutWAL(ctx, s, kind, entry)
+    if err != nil {
+        return "", fmt.Errorf("failed to write WAL entry: %v", err)
+    }
+
+    return walID, nil
+}
+
+// deleteUserWAL removes the WAL entry of a completed mutation. Failing to do
+// so is harmless since the rollback finds the mutation completed.
+func (b *minioBackend) deleteUserWAL(ctx context.Context, s logical.Storage, walID string) {
+    if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+        b.Logger().Warn("Failed to delete WAL entry", "id", walID, "error", err)
+    }
+}
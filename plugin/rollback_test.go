@@ -0,0 +1,197 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPluginWALRollback(t *testing.T) {
+    t.Run("Test Rollback Removes Partially Created User", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "policy_document":  TEST_POLICY_DOCUMENT,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        server.failAttach = true
+        _, err = testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.Error(t, err)
+        require.Equal(t, 1, server.userCount())
+        require.Len(t, server.cannedPolicies, 1)
+
+        wals, err := framework.ListWAL(context.Background(), reqStorage)
+        require.NoError(t, err)
+        require.Len(t, wals, 1)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.Equal(t, 0, server.userCount())
+        require.Empty(t, server.cannedPolicies)
+
+        wals, err = framework.ListWAL(context.Background(), reqStorage)
+        require.NoError(t, err)
+        require.Empty(t, wals)
+    })
+
+    t.Run("Test Rollback Keeps Stored User", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        accessKeyId := resp.Data["accessKeyId"].(string)
+
+        // As if the request completed but failed to delete its WAL entry
+        _, err = framework.PutWAL(context.Background(), reqStorage, "addUser", map[string]interface{}{
+            "role_name":     TEST_ROLE_NAME,
+            "access_key_id": accessKeyId,
+            "policy_name":   TEST_POLICY_NAME,
+        })
+        require.NoError(t, err)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.True(t, server.hasUser(accessKeyId))
+    })
+
+    t.Run("Test Rollback Completes Interrupted Removal", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        resp, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        accessKeyId := resp.Data["accessKeyId"].(string)
+
+        _, err = framework.PutWAL(context.Background(), reqStorage, "removeUser", map[string]interface{}{
+            "role_name":     TEST_ROLE_NAME,
+            "access_key_id": accessKeyId,
+            "policy_name":   TEST_POLICY_NAME,
+        })
+        require.NoError(t, err)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.False(t, server.hasUser(accessKeyId))
+
+        keys, err := reqStorage.List(context.Background(), userStoragePath+"/"+TEST_ROLE_NAME+"/")
+        require.NoError(t, err)
+        require.Empty(t, keys)
+    })
+
+    t.Run("Test Rollback Removes Partially Created Library User", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+
+        server.failAttach = true
+        _, err := testLibraryRequest(t, reqStorage, logical.CreateOperation, TEST_LIBRARY_SET, nil, map[string]interface{}{
+            "users":       TEST_LIBRARY_CREATED_USER,
+            "policy_name": TEST_POLICY_NAME,
+        })
+        require.Error(t, err)
+        require.True(t, server.hasUser(TEST_LIBRARY_CREATED_USER))
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.False(t, server.hasUser(TEST_LIBRARY_CREATED_USER))
+
+        wals, err := framework.ListWAL(context.Background(), reqStorage)
+        require.NoError(t, err)
+        require.Empty(t, wals)
+    })
+
+    t.Run("Test Rollback Sets Stored Secret Of Library User Taken Over", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        server.addUser(TEST_LIBRARY_EXISTING_USER, "initialSecret")
+
+        _, err := testLibraryRequest(t, reqStorage, logical.CreateOperation, TEST_LIBRARY_SET, nil, map[string]interface{}{
+            "users": TEST_LIBRARY_EXISTING_USER,
+        })
+        require.NoError(t, err)
+
+        // As if the request failed once the user was stored, before Minio
+        // got its new secret key
+        server.addUser(TEST_LIBRARY_EXISTING_USER, "initialSecret")
+        _, err = framework.PutWAL(context.Background(), reqStorage, "takeOverLibraryUser", map[string]interface{}{
+            "set_name": TEST_LIBRARY_SET,
+            "username": TEST_LIBRARY_EXISTING_USER,
+        })
+        require.NoError(t, err)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+
+        resp, err := testLibraryRequest(t, reqStorage, logical.UpdateOperation, TEST_LIBRARY_SET+"/check-out", &logical.Request{EntityID: "entity-1"}, nil)
+        require.NoError(t, err)
+        require.NotEqual(t, "initialSecret", resp.Data["secretAccessKey"])
+        require.Equal(t, resp.Data["secretAccessKey"], server.secretKey(TEST_LIBRARY_EXISTING_USER))
+    })
+
+    t.Run("Test Rollback Deletes Library User Of A Set Never Stored", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        server.addUser(TEST_LIBRARY_EXISTING_USER, "initialSecret")
+
+        key := "library-users/" + TEST_LIBRARY_SET + "/" + TEST_LIBRARY_EXISTING_USER
+        entry, err := logical.StorageEntryJSON(key, map[string]interface{}{
+            "access_key_id":     TEST_LIBRARY_EXISTING_USER,
+            "secret_access_key": "orphanSecret",
+            "available":         true,
+        })
+        require.NoError(t, err)
+        require.NoError(t, reqStorage.Put(context.Background(), entry))
+        _, err = framework.PutWAL(context.Background(), reqStorage, "takeOverLibraryUser", map[string]interface{}{
+            "set_name": TEST_LIBRARY_SET,
+            "username": TEST_LIBRARY_EXISTING_USER,
+        })
+        require.NoError(t, err)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.Equal(t, "initialSecret", server.secretKey(TEST_LIBRARY_EXISTING_USER))
+
+        entry, err = reqStorage.Get(context.Background(), key)
+        require.NoError(t, err)
+        require.Nil(t, entry)
+    })
+}
+
+// testRollback runs the rollback manager on every WAL entry regardless of age
+func testRollback(t *testing.T, s logical.Storage) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.RollbackOperation,
+        Data:      map[string]interface{}{"immediate": true},
+        Storage:   s,
+    })
+}
@@ -0,0 +1,241 @@
+package minio
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/hashicorp/vault/sdk/queue"
+)
+
+const (
+    // How often the rotation queue is checked for static roles due
+    queueTickInterval = time.Second
+
+    // How long to wait before retrying a failed rotation
+    rotationRetryDelay = 10 * time.Second
+)
+
+// walStaticRole records a static role's user about to get a new secret key.
+// WAL entries are not seal wrapped, so the secret key itself is left out.
+type walStaticRole struct {
+    Name       string    `json:"name"`
+    Username   string    `json:"username"`
+    Connection string    `json:"connection"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// initQueue loads the static roles into the rotation queue and starts
+// rotating them. Vault only initializes the backend where it may write.
+func (b *minioBackend) initQueue(ctx context.Context, req *logical.InitializationRequest) error {
+    names, err := req.Storage.List(ctx, staticRoleStoragePrefix)
+    if err != nil {
+        return fmt.Errorf("failed to list static roles: %v", err)
+    }
+
+    for _, name := range names {
+        role, err := b.getStaticRole(ctx, req.Storage, name)
+        if err != nil {
+            return err
+        }
+        if role != nil {
+            b.pushStaticRole(name, role.nextRotation())
+        }
+    }
+
+    queueCtx, cancel := context.WithCancel(context.Background())
+    b.cancelQueue = cancel
+    go b.runRotationQueue(queueCtx, req.Storage)
+
+    return nil
+}
+
+// clean stops the rotation of static roles when the backend is unloaded
+func (b *minioBackend) clean(_ context.Context) {
+    if b.cancelQueue != nil {
+        b.cancelQueue()
+    }
+}
+
+// runRotationQueue rotates the static roles as they come due until ctx is done
+func (b *minioBackend) runRotationQueue(ctx context.Context, s logical.Storage) {
+    ticker := time.NewTicker(queueTickInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            b.rotateDueStaticRoles(ctx, s)
+        }
+    }
+}
+
+// rotateDueStaticRoles pops and rotates every static role due
+func (b *minioBackend) rotateDueStaticRoles(ctx context.Context, s logical.Storage) {
+    for {
+        item, err := b.credRotationQueue.Pop()
+        if err != nil {
+            // The queue is empty
+            return
+        }
+
+        if item.Priority > time.Now().Unix() {
+            b.pushStaticRole(item.Key, time.Unix(item.Priority, 0))
+            return
+        }
+
+        b.rotateQueuedStaticRole(ctx, s, item.Key)
+    }
+}
+
+// rotateQueuedStaticRole rotates a static role popped from the queue and
+// queues its next rotation
+func (b *minioBackend) rotateQueuedStaticRole(ctx context.Context, s logical.Storage, name string) {
+    lock := b.staticRoleLock(name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    role, err := b.getStaticRole(ctx, s, name)
+    if err != nil {
+        b.Logger().Error("Reading static role for rotation failed", "name", name, "error", err)
+        b.pushStaticRole(name, time.Now().Add(rotationRetryDelay))
+        return
+    }
+
+    // Deleted since it was queued
+    if role == nil {
+        return
+    }
+
+    // Updated since it was queued
+    if time.Now().Before(role.nextRotation()) {
+        b.pushStaticRole(name, role.nextRotation())
+        return
+    }
+
+    if err := b.rotateStaticRole(ctx, s, name, role); err != nil {
+        b.Logger().Error("Rotating static role failed", "name", name, "username", role.Username, "error", err)
+        b.pushStaticRole(name, time.Now().Add(rotationRetryDelay))
+        return
+    }
+
+    b.pushStaticRole(name, role.nextRotation())
+}
+
+// rotateStaticRole sets a new secret key on the user of a static role and
+// stores it. The caller holds the static role lock.
+func (b *minioBackend) rotateStaticRole(ctx context.Context, s logical.Storage, name string, role *StaticRole) error {
+    b.Logger().Info("Rotating static role", "name", name, "username", role.Username)
+
+    c, err := b.GetConnection(ctx, s, role.Connection)
+    if err != nil {
+        return err
+    }
+
+    secretAccessKey, err := b.generateSecretAccessKey(ctx, c, role.PasswordPolicy)
+    if err != nil {
+        return err
+    }
+
+    // Record the rotation so it is completed should the request fail once
+    // Minio has the new secret key
+    walID, err := b.putStaticRoleWAL(ctx, s, &walStaticRole{
+        Name:       name,
+        Username:   role.Username,
+        Connection: role.Connection,
+        CreatedAt:  time.Now(),
+    })
+    if err != nil {
+        return err
+    }
+
+    if err := b.setStaticRoleSecret(ctx, s, name, role, secretAccessKey); err != nil {
+        // Without a user the secret key was never set
+        if isNoSuchUser(err) {
+            b.deleteUserWAL(ctx, s, walID)
+        }
+        return err
+    }
+    b.deleteUserWAL(ctx, s, walID)
+
+    return nil
+}
+
+// setStaticRoleSecret sets secretAccessKey on the user of a static role,
+// keeping its status, and stores it with the role
+func (b *minioBackend) setStaticRoleSecret(ctx context.Context, s logical.Storage, name string, role *StaticRole, secretAccessKey string) error {
+    if err := b.setUserSecret(ctx, s, role.Connection, role.Username, secretAccessKey); err != nil {
+        return err
+    }
+
+    role.SecretAccessKey = secretAccessKey
+    role.LastVaultRotation = time.Now()
+
+    return b.putStaticRole(ctx, s, name, role)
+}
+
+// rollbackRotateStaticRole finishes an interrupted rotation. Minio may
+// already use a secret key Vault never stored, so the user is rotated again
+// unless a later rotation completed.
+func (b *minioBackend) rollbackRotateStaticRole(ctx context.Context, req *logical.Request, data interface{}) error {
+    var entry walStaticRole
+    if err := decodeWAL(data, &entry); err != nil {
+        return err
+    }
+
+    lock := b.staticRoleLock(entry.Name)
+    lock.Lock()
+    defer lock.Unlock()
+
+    role, err := b.getStaticRole(ctx, req.Storage, entry.Name)
+    if err != nil {
+        return err
+    }
+
+    // The role was deleted or its user changed hands since
+    if role == nil || role.Username != entry.Username || role.Connection != entry.Connection {
+        return nil
+    }
+
+    // A later rotation completed, or this one did and only failed to
+    // delete its WAL entry
+    if role.LastVaultRotation.After(entry.CreatedAt) {
+        return nil
+    }
+
+    b.Logger().Info("Completing interrupted static role rotation", "name", entry.Name, "username", entry.Username)
+    if err := b.rotateStaticRole(ctx, req.Storage, entry.Name, role); err != nil {
+        return err
+    }
+
+    b.pushStaticRole(entry.Name, role.nextRotation())
+    return nil
+}
+
+// putStaticRoleWAL records a pending static role rotation
+func (b *minioBackend) putStaticRoleWAL(ctx context.Context, s logical.Storage, entry *walStaticRole) (string, error) {
+    walID, err := framework.PutWAL(ctx, s, walTypeRotateStaticRole, entry)
+    if err != nil {
+        return "", fmt.Errorf("failed to write WAL entry: %v", err)
+    }
+
+    return walID, nil
+}
+
+// pushStaticRole queues the rotation of a static role at the given time,
+// replacing any rotation already queued for it
+func (b *minioBackend) pushStaticRole(name string, at time.Time) {
+    b.credRotationQueue.PopByKey(name)
+
+    err := b.credRotationQueue.Push(&queue.Item{
+        Key:      name,
+        Priority: at.Unix(),
+    })
+    if err != nil {
+        b.Logger().Warn("Queueing static role rotation failed", "name", name, "error", err)
+    }
+}
@@ -0,0 +1,108 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/stretchr/testify/require"
+)
+
+func TestPluginStaticRoleRotation(t *testing.T) {
+    t.Run("Test Static Role Rotated By The Queue", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        server.addUser(TEST_STATIC_USERNAME, "initialSecret")
+
+        _, err := testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "username":        TEST_STATIC_USERNAME,
+            "rotation_period": 5,
+        })
+        require.NoError(t, err)
+        firstSecretKey := server.secretKey(TEST_STATIC_USERNAME)
+
+        // The queue is loaded from storage on initialization
+        b, err := getMinioBackend(t)
+        require.NoError(t, err)
+        require.NoError(t, b.Initialize(context.Background(), &logical.InitializationRequest{Storage: reqStorage}))
+        defer b.Cleanup(context.Background())
+
+        // Vault stores the secret key right after Minio gets it
+        require.Eventually(t, func() bool {
+            resp, err := testStaticCredsRead(t, reqStorage, TEST_STATIC_ROLE_NAME)
+            if err != nil {
+                return false
+            }
+            secretKey := resp.Data["secretAccessKey"]
+            return secretKey != firstSecretKey && secretKey == server.secretKey(TEST_STATIC_USERNAME)
+        }, 15*time.Second, 100*time.Millisecond)
+    })
+
+    t.Run("Test Rollback Completes Interrupted Rotation", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        server.addUser(TEST_STATIC_USERNAME, "initialSecret")
+
+        _, err := testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "username":        TEST_STATIC_USERNAME,
+            "rotation_period": "1h",
+        })
+        require.NoError(t, err)
+
+        // As if a rotation failed after Minio got a new secret key Vault
+        // never stored
+        server.addUser(TEST_STATIC_USERNAME, "lostSecret")
+        _, err = framework.PutWAL(context.Background(), reqStorage, "rotateStaticRole", map[string]interface{}{
+            "name":       TEST_STATIC_ROLE_NAME,
+            "username":   TEST_STATIC_USERNAME,
+            "created_at": time.Now().Add(time.Second),
+        })
+        require.NoError(t, err)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        secretKey := server.secretKey(TEST_STATIC_USERNAME)
+        require.NotEqual(t, "lostSecret", secretKey)
+
+        resp, err := testStaticCredsRead(t, reqStorage, TEST_STATIC_ROLE_NAME)
+        require.NoError(t, err)
+        require.Equal(t, secretKey, resp.Data["secretAccessKey"])
+
+        wals, err := framework.ListWAL(context.Background(), reqStorage)
+        require.NoError(t, err)
+        require.Empty(t, wals)
+    })
+
+    t.Run("Test Rollback Ignores Superseded Rotation", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        server.addUser(TEST_STATIC_USERNAME, "initialSecret")
+
+        _, err := framework.PutWAL(context.Background(), reqStorage, "rotateStaticRole", map[string]interface{}{
+            "name":       TEST_STATIC_ROLE_NAME,
+            "username":   TEST_STATIC_USERNAME,
+            "created_at": time.Now().Add(-time.Hour),
+        })
+        require.NoError(t, err)
+
+        _, err = testStaticRoleRequest(t, reqStorage, logical.CreateOperation, TEST_STATIC_ROLE_NAME, map[string]interface{}{
+            "username":        TEST_STATIC_USERNAME,
+            "rotation_period": "1h",
+        })
+        require.NoError(t, err)
+        secretKey := server.secretKey(TEST_STATIC_USERNAME)
+
+        _, err = testRollback(t, reqStorage)
+        require.NoError(t, err)
+        require.Equal(t, secretKey, server.secretKey(TEST_STATIC_USERNAME))
+
+        wals, err := framework.ListWAL(context.Background(), reqStorage)
+        require.NoError(t, err)
+        require.Empty(t, wals)
+    })
+}
@@ -0,0 +1,393 @@
+package minio
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/hashicorp/vault/sdk/framework"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+)
+
+const (
+    secretStaticType = "minio_static"
+    secretStsType    = "minio_sts"
+    secretServiceAccountType = "minio_service_account"
+    secretLibraryType = "minio_library"
+)
+
+// Secret type for static user credentials issued by creds/<role>
+func (b *minioBackend) secretStaticKeys() *framework.Secret {
+    return &framework.Secret{
+        Type: secretStaticType,
+        Fields: map[string]*framework.FieldSchema{
+            "accessKeyId": {
+                Type:        framework.TypeString,
+                Description: "Minio user access key ID.",
+            },
+            "secretAccessKey": {
+                Type:        framework.TypeString,
+                Description: "Minio user secret access key.",
+            },
+        },
+
+        Renew:  b.secretStaticKeysRenew,
+        Revoke: b.secretStaticKeysRevoke,
+    }
+}
+
+// Secret type for STS credentials issued by sts/<role>
+func (b *minioBackend) secretStsKeys() *framework.Secret {
+    return &framework.Secret{
+        Type: secretStsType,
+        Fields: map[string]*framework.FieldSchema{
+            "accessKeyId": {
+                Type:        framework.TypeString,
+                Description: "Temporary access key ID.",
+            },
+            "secretAccessKey": {
+                Type:        framework.TypeString,
+                Description: "Temporary secret access key.",
+            },
+            "sessionToken": {
+                Type:        framework.TypeString,
+                Description: "Session token for the temporary credentials.",
+            },
+        },
+
+        Renew:  b.secretStsKeysRenew,
+        Revoke: b.secretStsKeysRevoke,
+    }
+}
+
+// Secret type for service accounts issued by creds/<role>
+func (b *minioBackend) secretServiceAccountKeys() *framework.Secret {
+    return &framework.Secret{
+        Type: secretServiceAccountType,
+        Fields: map[string]*framework.FieldSchema{
+            "accessKeyId": {
+                Type:        framework.TypeString,
+                Description: "Service account access key ID.",
+            },
+            "secretAccessKey": {
+                Type:        framework.TypeString,
+                Description: "Service account secret access key.",
+            },
+            "expiration": {
+                Type:        framework.TypeString,
+                Description: "Time at which Minio expires the service account.",
+            },
+        },
+
+        Renew:  b.secretServiceAccountKeysRenew,
+        Revoke: b.secretServiceAccountKeysRevoke,
+    }
+}
+
+// Secret type for users checked out of library/<set>/check-out
+func (b *minioBackend) secretLibraryKeys() *framework.Secret {
+    return &framework.Secret{
+        Type: secretLibraryType,
+        Fields: map[string]*framework.FieldSchema{
+            "accessKeyId": {
+                Type:        framework.TypeString,
+                Description: "Minio user access key ID.",
+            },
+            "secretAccessKey": {
+                Type:        framework.TypeString,
+                Description: "Minio user secret access key.",
+            },
+        },
+
+        Renew:  b.secretLibraryKeysRenew,
+        Revoke: b.secretLibraryKeysRevoke,
+    }
+}
+
+// secretStaticKeysRenew extends a static credential lease, bounded by the
+// role's MaxTTL and by the expiration date of the underlying Minio user
+func (b *minioBackend) secretStaticKeysRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    roleName, accessKeyId, err := secretInternalData(req)
+    if err != nil {
+        return nil, err
+    }
+
+    lock := b.roleLock(roleName)
+    lock.RLock()
+    defer lock.RUnlock()
+
+    role, err := b.GetRole(ctx, req.Storage, roleName)
+    if err != nil {
+        return nil, fmt.Errorf("error fetching role: %v", err)
+    }
+
+    userCreds, err := b.findUserCreds(ctx, req.Storage, roleName, secretEntityID(req), accessKeyId)
+    if err != nil {
+        return nil, err
+    }
+    if userCreds == nil {
+        return nil, fmt.Errorf("user %s is no longer managed by role %s", accessKeyId, roleName)
+    }
+    if userCreds.Status == madmin.AccountDisabled {
+        return nil, fmt.Errorf("user %s of role %s was revoked", accessKeyId, roleName)
+    }
+
+    ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.MaxTTL, 0, role.MaxTTL, req.Secret.MaxTTL, req.Secret.IssueTime)
+    if err != nil {
+        return nil, err
+    }
+
+    // The lease must never outlive the Minio user it refers to
+    if remaining := time.Until(userCreds.ExpirationDate); remaining < ttl {
+        ttl = remaining
+    }
+
+    resp := &logical.Response{Secret: req.Secret}
+    resp.Secret.TTL = ttl
+    for _, warning := range warnings {
+        resp.AddWarning(warning)
+    }
+
+    return resp, nil
+}
+
+// secretStaticKeysRevoke releases the Minio user behind a static credential
+// lease, revoking it once no other lease uses it
+func (b *minioBackend) secretStaticKeysRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    roleName, accessKeyId, err := secretInternalData(req)
+    if err != nil {
+        return nil, err
+    }
+
+    lock := b.roleLock(roleName)
+    lock.Lock()
+    defer lock.Unlock()
+
+    userCreds, err := b.findUserCreds(ctx, req.Storage, roleName, secretEntityID(req), accessKeyId)
+    if err != nil {
+        return nil, err
+    }
+
+    // Rotated out, tidied or revoked by access key since
+    if userCreds == nil {
+        b.Logger().Debug("User already removed, nothing to revoke", "role", roleName, "accessKeyId", accessKeyId)
+        return nil, nil
+    }
+
+    // Other leases still use the user
+    if userCreds.Leases > 1 {
+        userCreds.Leases--
+        if err := b.putUserCreds(ctx, req.Storage, roleName, userCreds); err != nil {
+            return nil, err
+        }
+        return nil, nil
+    }
+
+    role, err := b.getIssuingRole(ctx, req.Storage, roleName, userCreds)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := b.revokeUser(ctx, req, role, roleName, userCreds); err != nil {
+        return nil, err
+    }
+
+    return nil, nil
+}
+
+// secretServiceAccountKeysRenew extends a service account lease up to the
+// role's MaxTTL. The lease max TTL is the service account lifetime.
+func (b *minioBackend) secretServiceAccountKeysRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    roleName, _, err := secretInternalData(req)
+    if err != nil {
+        return nil, err
+    }
+
+    role, err := b.GetRole(ctx, req.Storage, roleName)
+    if err != nil {
+        return nil, fmt.Errorf("error fetching role: %v", err)
+    }
+
+    ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.MaxTTL, 0, role.MaxTTL, req.Secret.MaxTTL, req.Secret.IssueTime)
+    if err != nil {
+        return nil, err
+    }
+
+    resp := &logical.Response{Secret: req.Secret}
+    resp.Secret.TTL = ttl
+    for _, warning := range warnings {
+        resp.AddWarning(warning)
+    }
+
+    return resp, nil
+}
+
+// secretServiceAccountKeysRevoke deletes the service account behind a lease
+func (b *minioBackend) secretServiceAccountKeysRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    _, accessKeyId, err := secretInternalData(req)
+    if err != nil {
+        return nil, err
+    }
+
+    connection, _ := req.Secret.InternalData["connection"].(string)
+    if err := b.removeServiceAccount(ctx, req.Storage, connection, accessKeyId); err != nil {
+        return nil, err
+    }
+
+    return nil, nil
+}
+
+// secretStsKeysRenew extends an STS lease up to the role's MaxStsTTL. The
+// lease max TTL is set to the token lifetime at issue time, so renewal can
+// never report the credentials as valid past their real expiration.
+func (b *minioBackend) secretStsKeysRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    roleName, _, err := secretInternalData(req)
+    if err != nil {
+        return nil, err
+    }
+
+    role, err := b.GetRole(ctx, req.Storage, roleName)
+    if err != nil {
+        return nil, fmt.Errorf("error fetching role: %v", err)
+    }
+
+    ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.MaxStsTTL, 0, role.MaxStsTTL, req.Secret.MaxTTL, req.Secret.IssueTime)
+    if err != nil {
+        return nil, err
+    }
+
+    resp := &logical.Response{Secret: req.Secret}
+    resp.Secret.TTL = ttl
+    for _, warning := range warnings {
+        resp.AddWarning(warning)
+    }
+
+    return resp, nil
+}
+
+// secretStsKeysRevoke is a no-op: Minio cannot revoke a single set of STS
+// credentials, they expire on their own or together with their parent user
+func (b *minioBackend) secretStsKeysRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    return nil, nil
+}
+
+// secretLibraryKeysRenew extends a check-out up to the set's MaxTTL, as long
+// as the user was not checked in meanwhile
+func (b *minioBackend) secretLibraryKeysRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    setName, user, err := b.secretLibraryUser(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    if user == nil {
+        return nil, fmt.Errorf("user is no longer checked out of library set %s", setName)
+    }
+
+    set, err := b.getLibrarySet(ctx, req.Storage, setName)
+    if err != nil {
+        return nil, err
+    }
+    if set == nil {
+        return nil, fmt.Errorf("library set %s not found", setName)
+    }
+
+    ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, set.TTL, 0, set.MaxTTL, req.Secret.MaxTTL, req.Secret.IssueTime)
+    if err != nil {
+        return nil, err
+    }
+
+    resp := &logical.Response{Secret: req.Secret}
+    resp.Secret.TTL = ttl
+    for _, warning := range warnings {
+        resp.AddWarning(warning)
+    }
+
+    return resp, nil
+}
+
+// secretLibraryKeysRevoke checks in the user of a check-out, unless it was
+// checked in already
+func (b *minioBackend) secretLibraryKeysRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+    setName, _ := req.Secret.InternalData["set"].(string)
+
+    lock := b.libraryLock(setName)
+    lock.Lock()
+    defer lock.Unlock()
+
+    _, user, err := b.secretLibraryUser(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    if user == nil {
+        b.Logger().Debug("User already checked in, nothing to revoke", "set", setName)
+        return nil, nil
+    }
+
+    set, err := b.getLibrarySet(ctx, req.Storage, setName)
+    if err != nil {
+        return nil, err
+    }
+    if set == nil {
+        return nil, fmt.Errorf("library set %s not found", setName)
+    }
+
+    if err := b.checkInLibraryUser(ctx, req.Storage, setName, set, user); err != nil {
+        return nil, err
+    }
+
+    return nil, nil
+}
+
+// secretLibraryUser returns the set name of a check-out lease and its user,
+// nil once the check-out is over
+func (b *minioBackend) secretLibraryUser(ctx context.Context, req *logical.Request) (string, *LibraryUser, error) {
+    if req.Secret == nil {
+        return "", nil, errors.New("request is missing secret")
+    }
+
+    setName, _ := req.Secret.InternalData["set"].(string)
+    accessKeyId, _ := req.Secret.InternalData["accessKeyId"].(string)
+    checkOutID, _ := req.Secret.InternalData["check_out_id"].(string)
+    if setName == "" || accessKeyId == "" || checkOutID == "" {
+        return "", nil, errors.New("secret is missing library internal data")
+    }
+
+    user, err := b.getLibraryUser(ctx, req.Storage, setName, accessKeyId)
+    if err != nil {
+        return "", nil, err
+    }
+
+    if user == nil || user.Available || user.CheckOutID != checkOutID {
+        return setName, nil, nil
+    }
+
+    return setName, user, nil
+}
+
+// secretInternalData extracts the role name and access key ID stored with a lease
+func secretInternalData(req *logical.Request) (string, string, error) {
+    if req.Secret == nil {
+        return "", "", errors.New("request is missing secret")
+    }
+
+    roleName, ok := req.Secret.InternalData["role"].(string)
+    if !ok || roleName == "" {
+        return "", "", errors.New("secret is missing role internal data")
+    }
+
+    accessKeyId, ok := req.Secret.InternalData["accessKeyId"].(string)
+    if !ok || accessKeyId == "" {
+        return "", "", errors.New("secret is missing accessKeyId internal data")
+    }
+
+    return roleName, accessKeyId, nil
+}
+
+// secretEntityID returns the entity a static lease's user was issued to,
+// empty for users shared by the role and for leases issued before it was
+// recorded
+func secretEntityID(req *logical.Request) string {
+    entityID, _ := req.Secret.InternalData["entityId"].(string)
+    return entityID
+}
@@ -0,0 +1,207 @@
+package minio_test
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    minio "github.com/jayxiong1/vault-plugin-secrets-minio/plugin"
+    "github.com/hashicorp/vault/sdk/logical"
+    "github.com/minio/madmin-go/v3"
+    "github.com/stretchr/testify/require"
+)
+
+const (
+    TEST_SECRET_STATIC_TYPE = "minio_static"
+    TEST_SECRET_STS_TYPE    = "minio_sts"
+    TEST_SECRET_SERVICE_ACCOUNT_TYPE = "minio_service_account"
+)
+
+func TestSecretStaticKeysRevoke(t *testing.T) {
+    t.Run("Test Static Lease Revoke When User Already Removed", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        resp, err := testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "userAccesskey",
+        })
+        require.NoError(t, err)
+        require.Nil(t, resp)
+    })
+
+    t.Run("Test Static Lease Revoke Keeps User Shared With Other Leases", func(t *testing.T) {
+        server := newTestMinioServer(t)
+        reqStorage := new(logical.InmemStorage)
+        server.configure(t, reqStorage)
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "policy_name":     TEST_POLICY_NAME,
+            "credential_type": TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        first, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        second, err := testPathKeysCreateStaticCredentials(t, reqStorage, TEST_ROLE_NAME)
+        require.NoError(t, err)
+        accessKeyId := first.Data["accessKeyId"].(string)
+        require.Equal(t, accessKeyId, second.Data["accessKeyId"])
+
+        _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, first.Secret.InternalData)
+        require.NoError(t, err)
+        require.True(t, server.hasUser(accessKeyId))
+
+        _, err = testSecretRenew(t, reqStorage, TEST_SECRET_STATIC_TYPE, second.Secret.InternalData)
+        require.NoError(t, err)
+
+        _, err = testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, second.Secret.InternalData)
+        require.NoError(t, err)
+        require.False(t, server.hasUser(accessKeyId))
+    })
+
+    t.Run("Test Static Lease Revoke Error When Internal Data Missing", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        _, err := testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, map[string]interface{}{
+            "role": TEST_ROLE_NAME,
+        })
+        require.Error(t, err)
+    })
+
+    t.Run("Test Static Lease Revoke Error When Getting Minio Admin Client Returns Error", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        testPutUserCreds(t, reqStorage, TEST_ROLE_NAME, time.Now().Add(time.Hour))
+
+        _, err := testSecretRevoke(t, reqStorage, TEST_SECRET_STATIC_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "userAccesskey",
+        })
+        require.Error(t, err)
+    })
+}
+
+func TestSecretStaticKeysRenew(t *testing.T) {
+    t.Run("Test Static Lease Renew Error When Role Not Found", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        _, err := testSecretRenew(t, reqStorage, TEST_SECRET_STATIC_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "userAccesskey",
+        })
+        require.Error(t, err)
+    })
+
+    t.Run("Test Static Lease Renew Capped By User Expiration", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "max_ttl":          TEST_MAX_TTL,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+        testPutUserCreds(t, reqStorage, TEST_ROLE_NAME, time.Now().Add(time.Hour))
+
+        resp, err := testSecretRenew(t, reqStorage, TEST_SECRET_STATIC_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "userAccesskey",
+        })
+        require.NoError(t, err)
+        require.NotNil(t, resp.Secret)
+        require.LessOrEqual(t, resp.Secret.TTL, time.Hour)
+    })
+
+    t.Run("Test Static Lease Renew Error When User No Longer Exists", func(t *testing.T) {
+        reqStorage := new(logical.InmemStorage)
+        _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+            "role":             TEST_ROLE_NAME,
+            "policy_name":      TEST_POLICY_NAME,
+            "credential_type":  TEST_STATIC_CREDENTIAL_TYPE,
+        })
+        require.NoError(t, err)
+
+        _, err = testSecretRenew(t, reqStorage, TEST_SECRET_STATIC_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "userAccesskey",
+        })
+        require.Error(t, err)
+    })
+}
+
+func TestSecretStsKeys(t *testing.T) {
+    reqStorage := new(logical.InmemStorage)
+    _, err := testRoleCreateOrUpdate(t, reqStorage, TEST_ROLE_NAME, map[string]interface{}{
+        "role":             TEST_ROLE_NAME,
+        "policy_name":      TEST_POLICY_NAME,
+        "policy_document":  TEST_POLICY_DOCUMENT,
+        "max_sts_ttl":      TEST_MAX_STS_TTL,
+        "credential_type":  TEST_STS_CREDENTIAL_TYPE,
+    })
+    require.NoError(t, err)
+
+    t.Run("Test STS Lease Renew Capped By Role Max STS TTL", func(t *testing.T) {
+        resp, err := testSecretRenew(t, reqStorage, TEST_SECRET_STS_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "stsAccesskey",
+        })
+        require.NoError(t, err)
+        require.NotNil(t, resp.Secret)
+        require.LessOrEqual(t, resp.Secret.TTL, time.Duration(TEST_MAX_STS_TTL)*time.Second)
+    })
+
+    t.Run("Test STS Lease Revoke Is A No-op", func(t *testing.T) {
+        resp, err := testSecretRevoke(t, reqStorage, TEST_SECRET_STS_TYPE, map[string]interface{}{
+            "role":        TEST_ROLE_NAME,
+            "accessKeyId": "stsAccesskey",
+        })
+        require.NoError(t, err)
+        require.Nil(t, resp)
+    })
+}
+
+func testPutUserCreds(t *testing.T, s logical.Storage, roleName string, expiration time.Time) {
+    t.Helper()
+    userInfo := minio.UserInfo{
+        AccessKeyID:     "userAccesskey",
+        SecretAccessKey: "secretAccessKey",
+        PolicyName:      TEST_POLICY_NAME,
+        Status:          madmin.AccountEnabled,
+        ExpirationDate:  expiration,
+    }
+
+    userMap := map[string][]minio.UserInfo{roleName: {userInfo}}
+    entry, err := logical.StorageEntryJSON(userStoragePath, userMap)
+    require.NoError(t, err)
+    require.NoError(t, s.Put(context.Background(), entry))
+}
+
+func testSecretRenew(t *testing.T, s logical.Storage, secretType string, internal map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.RenewOperation,
+        Storage:   s,
+        Secret:    testSecret(secretType, internal),
+    })
+}
+
+func testSecretRevoke(t *testing.T, s logical.Storage, secretType string, internal map[string]interface{}) (*logical.Response, error) {
+    t.Helper()
+    b, _ := getMinioBackend(t)
+    return b.HandleRequest(context.Background(), &logical.Request{
+        Operation: logical.RevokeOperation,
+        Storage:   s,
+        Secret:    testSecret(secretType, internal),
+    })
+}
+
+func testSecret(secretType string, internal map[string]interface{}) *logical.Secret {
+    internalData := map[string]interface{}{"secret_type": secretType}
+    for k, v := range internal {
+        internalData[k] = v
+    }
+
+    return &logical.Secret{
+        LeaseOptions: logical.LeaseOptions{
+            TTL:       time.Minute,
+            IssueTime: time.Now(),
+        },
+        InternalData: internalData,
+    }
+}